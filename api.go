@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Largest request body accepted by the API
+const MAX_API_BODY = 64 * 1024
+
+// Allows reports whether the token can control the guild
+func (t *APIToken) Allows(guildID string) bool {
+	return len(t.Guilds) <= 0 || scontains(guildID, t.Guilds...)
+}
+
+// Mode names used by the API
+var modeNames = map[int]string{
+	0:        "normal",
+	RNG4EVER: "rng4ever",
+}
+
+// APISound describes a sound in a collection
+type APISound struct {
+	Name     string   `json:"name"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Duration float64  `json:"duration"`
+}
+
+// APICollection describes a collection and its sounds
+type APICollection struct {
+	Name   string     `json:"name"`
+	Sounds []APISound `json:"sounds"`
+}
+
+// APIGuild describes the playback state of a guild
+type APIGuild struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Connected bool      `json:"connected"`
+	Channel   string    `json:"channel,omitempty"`
+	Mode      string    `json:"mode"`
+	Playing   *PlayRef  `json:"playing,omitempty"`
+	Queue     []PlayRef `json:"queue"`
+}
+
+// APIPlayRequest is the body of a play request, the sound is random if it's not given
+type APIPlayRequest struct {
+	Collection string `json:"collection"`
+	Sound      string `json:"sound"`
+	Channel    string `json:"channel"`
+	User       string `json:"user"`
+	Effects    string `json:"effects"`
+}
+
+// APIModeRequest is the body of a mode change request
+type APIModeRequest struct {
+	Mode string `json:"mode"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// Decodes the JSON request body into v, bodies over MAX_API_BODY are rejected
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_API_BODY)).Decode(v)
+}
+
+// Finds the token given in the Authorization header, or in the token parameter for clients
+// which can't set headers (such as EventSource in browsers)
+func authenticate(r *http.Request) *APIToken {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if given == "" {
+		given = r.URL.Query().Get("token")
+	}
+	return findToken(given)
+}
+
+// Finds the token in the current configuration, returns nil if it isn't there (anymore)
+func findToken(given string) *APIToken {
+	if given == "" {
+		return nil
+	}
+
+	tokens := getConfig().API.Tokens
+	for i := range tokens {
+		if subtle.ConstantTimeCompare([]byte(tokens[i].Token), []byte(given)) == 1 {
+			return &tokens[i]
+		}
+	}
+	return nil
+}
+
+// Finds the Discord user plays are attributed to
+func apiUser(userID string, guild *discordgo.Guild) *discordgo.User {
+	if member, _ := discord.State.Member(guild.ID, userID); member != nil && member.User != nil {
+		return member.User
+	}
+	return &discordgo.User{ID: userID}
+}
+
+// Routes the API requests
+func handleAPI(w http.ResponseWriter, r *http.Request) {
+	token := authenticate(r)
+	if token == nil {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "me":
+		apiMe(w, r, token)
+	case len(parts) == 1 && parts[0] == "events":
+		apiEvents(w, r, token)
+	case len(parts) == 1 && parts[0] == "collections":
+		apiCollections(w, r)
+	case len(parts) == 1 && parts[0] == "guilds":
+		apiGuilds(w, r, token)
+	case len(parts) >= 2 && parts[0] == "guilds":
+		apiGuild(w, r, token, parts[1], parts[2:])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// GET /api/me
+func apiMe(w http.ResponseWriter, r *http.Request, token *APIToken) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":   token.Name,
+		"user":   token.User,
+		"guilds": token.Guilds,
+	})
+}
+
+// GET /api/collections
+func apiCollections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	soundsMutex.RLock()
+	collections := []APICollection{}
+	for _, coll := range COLLECTIONS {
+		c := APICollection{Name: coll.Prefix, Sounds: []APISound{}}
+		for _, sound := range coll.Sounds {
+			c.Sounds = append(c.Sounds, APISound{
+				Name:     sound.Name,
+				Aliases:  sound.Aliases,
+				Duration: sound.Duration().Seconds(),
+			})
+		}
+		collections = append(collections, c)
+	}
+	soundsMutex.RUnlock()
+
+	writeJSON(w, http.StatusOK, collections)
+}
+
+// GET /api/guilds
+func apiGuilds(w http.ResponseWriter, r *http.Request, token *APIToken) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	discord.State.RLock()
+	guilds := []*discordgo.Guild{}
+	for _, guild := range discord.State.Guilds {
+		if token.Allows(guild.ID) {
+			guilds = append(guilds, guild)
+		}
+	}
+	discord.State.RUnlock()
+
+	result := []APIGuild{}
+	for _, guild := range guilds {
+		result = append(result, describeGuild(getGuild(guild)))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Describes the guild's playback state
+func describeGuild(g *Guild) APIGuild {
+	result := APIGuild{
+		ID:    g.Guild.ID,
+		Name:  g.Guild.Name,
+		Mode:  modeNames[g.State],
+		Queue: []PlayRef{},
+	}
+
+	if vc := g.VoiceConnection; vc != nil {
+		result.Connected = true
+		result.Channel = vc.ChannelID
+	}
+	if playing := g.NowPlaying(); playing != nil {
+		ref := refPlay(playing)
+		result.Playing = &ref
+	}
+	for _, play := range g.Queued() {
+		result.Queue = append(result.Queue, refPlay(play))
+	}
+	return result
+}
+
+// Routes requests to /api/guilds/<id>/...
+func apiGuild(w http.ResponseWriter, r *http.Request, token *APIToken, guildID string, parts []string) {
+	if !token.Allows(guildID) {
+		writeError(w, http.StatusForbidden, "token can't control this guild")
+		return
+	}
+
+	guild, _ := discord.State.Guild(guildID)
+	if guild == nil {
+		writeError(w, http.StatusNotFound, "unknown guild")
+		return
+	}
+	g := getGuild(guild)
+
+	action := ""
+	if len(parts) > 0 {
+		action = parts[0]
+	}
+
+	method := http.MethodPost
+	if scontains(action, "", "queue", "history") {
+		method = http.MethodGet
+	}
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	switch action {
+	case "":
+		writeJSON(w, http.StatusOK, describeGuild(g))
+	case "queue":
+		writeJSON(w, http.StatusOK, describeGuild(g).Queue)
+	case "history":
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		writeJSON(w, http.StatusOK, g.FilterHistory(n, r.URL.Query().Get("user"), r.URL.Query().Get("collection")))
+	case "play":
+		apiPlay(w, r, token, g)
+	case "skip":
+		if token.User != "" {
+			g.SkipUser = apiUser(token.User, g.Guild)
+		}
+		g.SkipPending = true
+		writeJSON(w, http.StatusAccepted, describeGuild(g))
+	case "stop":
+		if g.VoiceConnection != nil {
+			g.Stop(reasonCommand, true)
+		}
+		writeJSON(w, http.StatusAccepted, describeGuild(g))
+	case "mode":
+		apiMode(w, r, g)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// POST /api/guilds/<id>/play
+func apiPlay(w http.ResponseWriter, r *http.Request, token *APIToken, g *Guild) {
+	req := APIPlayRequest{}
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	coll := findCollection(req.Collection)
+	if coll == nil {
+		writeError(w, http.StatusNotFound, "unknown collection")
+		return
+	}
+
+	effects, rest, err := parseEffects(strings.Fields(req.Effects))
+	if err == nil && len(rest) > 0 {
+		err = fmt.Errorf("unknown effect `%s`", rest[0])
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var sound *Sound
+	if req.Sound != "" {
+		var suggestions []*Sound
+		sound, suggestions = coll.Find(req.Sound)
+		if sound == nil {
+			names := []string{}
+			for _, s := range suggestions {
+				names = append(names, s.Name)
+			}
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{
+				"error":       "unknown sound",
+				"suggestions": names,
+			})
+			return
+		}
+		if !effects.None() && !effects.Fits(len(sound.Frames())) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("effects can only be used on clips up to %s", MAX_EFFECTS_LENGTH))
+			return
+		}
+	}
+
+	if sound == nil && coll.Empty() {
+		writeError(w, http.StatusConflict, "collection has no sounds")
+		return
+	}
+
+	// Only tokens trusted to impersonate can attribute plays to other users
+	userID := token.User
+	if token.Impersonate && req.User != "" {
+		userID = req.User
+	}
+	if userID == "" {
+		if token.Impersonate {
+			writeError(w, http.StatusBadRequest, "user is required")
+		} else {
+			writeError(w, http.StatusForbidden, "token has no user")
+		}
+		return
+	}
+	user := apiUser(userID, g.Guild)
+
+	var play *Play
+	if req.Channel != "" {
+		channel, _ := discord.State.Channel(req.Channel)
+		if channel == nil || channel.GuildID != g.Guild.ID || channel.Type != discordgo.ChannelTypeGuildVoice {
+			writeError(w, http.StatusBadRequest, "unknown voice channel")
+			return
+		}
+		play = createPlayIn(user, g.Guild, channel, coll, sound)
+		if play == nil {
+			writeError(w, http.StatusConflict, "collection has no sounds")
+			return
+		}
+		play.Pinned = true
+	} else {
+		play = createPlay(user, g.Guild, coll, sound)
+		if play == nil {
+			writeError(w, http.StatusConflict, "user is not in a voice channel")
+			return
+		}
+	}
+
+	play.Effects = effects
+
+	log.WithFields(log.Fields{
+		"token": token.Name,
+		"guild": g.Guild.Name,
+		"sound": play.Sound.Name,
+	}).Info("Play requested over API")
+
+	go queuePlay(play)
+	writeJSON(w, http.StatusAccepted, refPlay(play))
+}
+
+// POST /api/guilds/<id>/mode
+func apiMode(w http.ResponseWriter, r *http.Request, g *Guild) {
+	req := APIModeRequest{}
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	for mode, name := range modeNames {
+		if name == req.Mode {
+			g.SetMode(mode)
+			writeJSON(w, http.StatusOK, describeGuild(g))
+			return
+		}
+	}
+	writeError(w, http.StatusBadRequest, "unknown mode")
+}
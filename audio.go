@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"layeh.com/gopus"
+)
+
+// PCM format of the audio sent to Discord
+const (
+	AUDIO_SAMPLE_RATE = 48000
+	AUDIO_CHANNELS    = 2
+
+	// Samples per channel in a single 20ms frame
+	AUDIO_FRAME_SIZE = 960
+
+	// Upper limit of an encoded Opus frame
+	MAX_OPUS_FRAME_BYTES = 4000
+
+	// Time ffmpeg gets to start up, on top of the time it gets for decoding
+	DECODE_STARTUP_TIMEOUT = 10 * time.Second
+)
+
+// Number of PCM samples per channel in the duration
+func samplesIn(d time.Duration) int {
+	return int(d.Seconds() * AUDIO_SAMPLE_RATE)
+}
+
+// Decodes audio of any format ffmpeg understands to interleaved PCM,
+// reading at most maxDuration of it (and a frame more, so longer audio can be detected).
+// ffmpeg is killed if it takes longer than it should, malformed files can make it hang.
+func decodeAudio(r io.Reader, maxDuration time.Duration) ([]int16, error) {
+	limit := maxDuration + FRAME_DURATION
+
+	// Decoding is far faster than real time, so the audio's length is plenty
+	timeout := DECODE_STARTUP_TIMEOUT + maxDuration
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-i", "pipe:0",
+		"-t", fmt.Sprintf("%.3f", limit.Seconds()),
+		"-f", "s16le",
+		"-ar", fmt.Sprint(AUDIO_SAMPLE_RATE),
+		"-ac", fmt.Sprint(AUDIO_CHANNELS),
+		"pipe:1")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = r
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("ffmpeg took longer than %s", timeout)
+		}
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, lines[len(lines)-1])
+	}
+
+	pcm := make([]int16, stdout.Len()/2)
+	if err := binary.Read(&stdout, binary.LittleEndian, pcm); err != nil {
+		return nil, err
+	}
+	return pcm, nil
+}
+
+// Encodes interleaved PCM to Opus frames with the configured bitrate, the last frame is padded with silence
+func encodeOpus(pcm []int16) ([][]byte, error) {
+	encoder, err := gopus.NewEncoder(AUDIO_SAMPLE_RATE, AUDIO_CHANNELS, gopus.Audio)
+	if err != nil {
+		return nil, err
+	}
+	encoder.SetBitrate(getConfig().Bitrate * 1000)
+
+	frameLength := AUDIO_FRAME_SIZE * AUDIO_CHANNELS
+	frames := [][]byte{}
+	for i := 0; i < len(pcm); i += frameLength {
+		frame := pcm[i:minInt(i+frameLength, len(pcm))]
+		if len(frame) < frameLength {
+			frame = append(append([]int16{}, frame...), make([]int16, frameLength-len(frame))...)
+		}
+
+		opus, err := encoder.Encode(frame, AUDIO_FRAME_SIZE, MAX_OPUS_FRAME_BYTES)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, opus)
+	}
+	return frames, nil
+}
+
+// Decodes Opus frames to interleaved PCM
+func decodeOpus(frames [][]byte) ([]int16, error) {
+	decoder, err := gopus.NewDecoder(AUDIO_SAMPLE_RATE, AUDIO_CHANNELS)
+	if err != nil {
+		return nil, err
+	}
+
+	pcm := make([]int16, 0, len(frames)*AUDIO_FRAME_SIZE*AUDIO_CHANNELS)
+	for _, frame := range frames {
+		decoded, err := decoder.Decode(frame, AUDIO_FRAME_SIZE, false)
+		if err != nil {
+			return nil, err
+		}
+		pcm = append(pcm, decoded...)
+	}
+	return pcm, nil
+}
+
+// Reads the Opus frames of a DCA file, returning the frames read so far on error
+func readDCA(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	frames := [][]byte{}
+	var opuslen int16
+	for {
+		// read opus frame length from dca file
+		err = binary.Read(file, binary.LittleEndian, &opuslen)
+
+		// If this is the end of the file, just return
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+
+		// read encoded pcm from dca file, should not be any end of file errors
+		frame := make([]byte, opuslen)
+		if err := binary.Read(file, binary.LittleEndian, &frame); err != nil {
+			return frames, err
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// Writes the Opus frames as a DCA file, replacing an existing file atomically
+func writeDCA(path string, frames [][]byte) error {
+	var buf bytes.Buffer
+	for _, frame := range frames {
+		binary.Write(&buf, binary.LittleEndian, int16(len(frame)))
+		buf.Write(frame)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -2,15 +2,17 @@ package main
 
 import (
 	"bytes"
-	"encoding/binary"
 	"flag"
 	"fmt"
-	"io"
 	"math/rand"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
@@ -33,22 +35,16 @@ var (
 	// Redis client connection (used for stats)
 	rcli *redis.Client
 
-	// Holds extra data about the guild state
-	guilds = make(map[string]*Guild)
+	// Holds extra data about the guild state, use getGuild, lookupGuild and allGuilds
+	guilds      = make(map[string]*Guild)
+	guildsMutex sync.RWMutex
 
 	// SoundCount is the total count of all sounds
 	SoundCount = 0
-
-	// OWNER of the bot (Discord user ID)
-	OWNER string
 )
 
-// Sound encoding settings
-const (
-	BITRATE          = 128
-	MAX_QUEUE_SIZE   = 12
-	MAX_HISTORY_SIZE = 20
-)
+// Duration of a single DCA frame
+const FRAME_DURATION = 20 * time.Millisecond
 
 // Play represents an individual play of a sound to a voice channel
 type Play struct {
@@ -62,12 +58,28 @@ type Play struct {
 
 	// If true, this play was skipped
 	Skipped bool
+
+	// User who skipped the play, nil if it was skipped by the bot itself
+	SkippedBy *discordgo.User
+
+	// Effects applied to the sound when it's played
+	Effects Effects
+
+	// If true, the channel was picked explicitly and the follow policy doesn't apply
+	Pinned bool
 }
 
 // Sound represents an individual sound clip
 type Sound struct {
 	Name string
 
+	// Identifier of the sound in stats, kept when the sound is renamed or moved.
+	// Empty until the sound is renamed, the collection and name are used instead.
+	ID string
+
+	// Alternative names the sound can be requested with
+	Aliases []string
+
 	// Weight adjust how likely it is this song will play, higher = more likely
 	Weight int
 
@@ -77,6 +89,13 @@ type Sound struct {
 	// Buffer to store encoded PCM packets
 	buffer [][]byte
 
+	// Frames of the sound's file, buffer leaves out silence at the ends in trim mode.
+	// Edits start from these, so silence trimming never ends up in the file.
+	file [][]byte
+
+	// Number of silent frames found at the ends of the file
+	silence int
+
 	// Reference back to the collection
 	Collection *SoundCollection
 }
@@ -95,16 +114,51 @@ func createSound(Name string, Weight int, PartDelay int, collection *SoundCollec
 	}
 }
 
+// Frames returns the sound's Opus frames. Edits replace the frames instead of changing them,
+// so the frames can be used after the lock is released.
+func (s *Sound) Frames() [][]byte {
+	soundsMutex.RLock()
+	defer soundsMutex.RUnlock()
+	return s.buffer
+}
+
+// Duration of the sound, each DCA frame holds 20ms of audio, the caller must hold soundsMutex
+func (s *Sound) Duration() time.Duration {
+	return time.Duration(len(s.buffer)) * FRAME_DURATION
+}
+
+// Length of the sound like Duration, for callers that don't hold soundsMutex
+func (s *Sound) Length() time.Duration {
+	return time.Duration(len(s.Frames())) * FRAME_DURATION
+}
+
+// Size of the sound's Opus frames in bytes
+func (s *Sound) Size() int {
+	size := 0
+	for _, frame := range s.buffer {
+		size += len(frame)
+	}
+	return size
+}
+
 // Load all sounds from a collection
 func (sc *SoundCollection) Load() {
 	for _, sound := range sc.Sounds {
 		sc.soundRange += sound.Weight
 		sound.Load(sc)
 	}
+	sc.reportSilence()
 }
 
-// Random sound from the collection
+// Random sound from the collection, nil if the collection has no sounds left
 func (sc *SoundCollection) Random() *Sound {
+	soundsMutex.RLock()
+	defer soundsMutex.RUnlock()
+
+	if sc.soundRange <= 0 {
+		return nil
+	}
+
 	var (
 		i      int
 		number = randomRange(0, sc.soundRange)
@@ -126,58 +180,102 @@ func (sc *SoundCollection) Random() *Sound {
 // https://github.com/nstafie/dca-rs
 // eg: dca-rs --raw -i <input wav file> > <output file>
 func (s *Sound) Load(c *SoundCollection) error {
-	path := fmt.Sprintf("audio/%v/%v.dca", c.Prefix, s.Name)
-
-	file, err := os.Open(path)
+	frames, err := readDCA(s.Path())
+	s.setFrames(frames)
 
 	if err != nil {
-		fmt.Println("error opening dca file :", err)
+		fmt.Println("error reading from dca file :", err)
 		return err
 	}
+	return nil
+}
 
-	var opuslen int16
-
-	for {
-		// read opus frame length from dca file
-		err = binary.Read(file, binary.LittleEndian, &opuslen)
-
-		// If this is the end of the file, just return
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
-			return nil
-		}
-
-		if err != nil {
-			fmt.Println("error reading from dca file :", err)
-			return err
-		}
+// Path of the sound's DCA file
+func (s *Sound) Path() string {
+	return s.sidecarPath(".dca")
+}
 
-		// read encoded pcm from dca file
-		InBuf := make([]byte, opuslen)
-		err = binary.Read(file, binary.LittleEndian, &InBuf)
+// Path of the sound's file with the given extension
+func (s *Sound) sidecarPath(ext string) string {
+	return filepath.Join(getConfig().AudioDir, s.Collection.Prefix, s.Name+ext)
+}
 
-		// Should not be any end of file errors
-		if err != nil {
-			fmt.Println("error reading from dca file :", err)
-			return err
-		}
+// Render the sound's frames with the effects, the sound is played as it is if they fail
+func (s *Sound) Render(effects Effects) [][]byte {
+	frames := s.Frames()
+	if effects.None() {
+		return frames
+	}
+	if !effects.Fits(len(frames)) {
+		log.WithFields(log.Fields{
+			"sound":   s.Name,
+			"effects": effects.String(),
+		}).Info("Sound is too long for effects, playing it as it is")
+		return frames
+	}
 
-		// append encoded pcm data to the buffer
-		s.buffer = append(s.buffer, InBuf)
+	rendered, err := effects.Render(frames)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"sound":   s.Name,
+			"effects": effects.String(),
+			"error":   err,
+		}).Warning("Failed to apply effects, playing the sound as it is")
+		return frames
 	}
+	return rendered
 }
 
-// Play the sound over the specified VoiceConnection
-func (s *Sound) Play(vc *discordgo.VoiceConnection) bool {
+// Play the sound's frames over the specified VoiceConnection, crossfading to the next play if the guild uses crossfades
+func (s *Sound) Play(vc *discordgo.VoiceConnection, frames [][]byte) bool {
 	vc.Speaking(true)
 	defer vc.Speaking(false)
 
-	guildData := guilds[vc.GuildID]
-	for _, buff := range s.buffer {
+	guildData := lookupGuild(vc.GuildID)
+	var (
+		transcoder *Transcoder
+		fade       *Crossfade
+	)
+	for i, buff := range frames {
+		if fade == nil {
+			fade = guildData.startCrossfade(len(frames) - i)
+		}
+
+		// Frames are sent as they are at the default volume, other volumes, overlays and crossfades need transcoding
+		volume, mixing := guildData.Volume(), guildData.Mixing()
+		if volume != DEFAULT_VOLUME || mixing || fade != nil {
+			reason := "volume"
+			if fade != nil {
+				reason = "crossfade"
+			} else if mixing {
+				reason = "overlay"
+			}
+			if transcoder == nil {
+				transcoder, _ = newTranscoder(reason)
+			} else {
+				transcoder.reason = reason
+			}
+
+			frame, err := transcoder.Apply(buff, func(pcm []int16) {
+				if fade != nil {
+					fade.mix(pcm)
+				}
+				if mixing {
+					guildData.mixOverlays(pcm)
+				}
+				applyVolume(pcm, volume)
+			})
+			if err == nil {
+				buff = frame
+			}
+		}
+
+		start := time.Now()
 		vc.OpusSend <- buff
+		metricOpusSend.Observe(time.Since(start).Seconds())
 
 		if guildData != nil && (guildData.SkipPending || guildData.DisconnectPending) {
 			guildData.SkipPending = false
-			skipSound(s)
 			return true
 		}
 	}
@@ -213,9 +311,14 @@ func createPlay(user *discordgo.User, guild *discordgo.Guild, coll *SoundCollect
 		return nil
 	}
 
+	return createPlayIn(user, guild, channel, coll, sound)
+}
+
+// Prepares a play to the given voice channel, returns nil if there's no sound to play
+func createPlayIn(user *discordgo.User, guild *discordgo.Guild, channel *discordgo.Channel, coll *SoundCollection, sound *Sound) *Play {
 	// Create the play
 	play := &Play{
-		Guild:   guilds[guild.ID],
+		Guild:   lookupGuild(guild.ID),
 		Channel: channel,
 		User:    user,
 		Sound:   sound,
@@ -228,37 +331,65 @@ func createPlay(user *discordgo.User, guild *discordgo.Guild, coll *SoundCollect
 		play.Sound = coll.Random()
 		play.Forced = false
 	}
+	if play.Sound == nil {
+		log.WithFields(log.Fields{
+			"collection": coll.Prefix,
+			"guild":      guild.ID,
+		}).Warning("Collection has no sounds to play")
+		return nil
+	}
 
 	return play
 }
 
 // Prepares and enqueues a play into the ratelimit/buffer guild queue
 func enqueuePlay(user *discordgo.User, guild *discordgo.Guild, coll *SoundCollection, sound *Sound) {
+	enqueuePlayWith(user, guild, coll, sound, Effects{})
+}
+
+// Prepares and enqueues a play with effects
+func enqueuePlayWith(user *discordgo.User, guild *discordgo.Guild, coll *SoundCollection, sound *Sound, effects Effects) {
 	play := createPlay(user, guild, coll, sound)
 	if play == nil {
 		return
 	}
+	play.Effects = effects
+	queuePlay(play)
+}
 
-	guildData := guilds[guild.ID]
+// Enqueues the prepared play, running the guild's player until the queue is empty if it isn't running yet
+func queuePlay(play *Play) {
+	if isShuttingDown() {
+		return
+	}
 
-	if guildData.Queue == nil {
-		guildData.Queue = make(chan *Play, MAX_QUEUE_SIZE)
+	guildData := play.Guild
+	if guildData.Overlay(play) {
+		return
+	}
 
-		if len(guildData.Queue) < MAX_QUEUE_SIZE {
-			guildData.Queue <- play
-		}
+	start := guildData.Enqueue(play)
+	saveState()
 
+	if start {
 		guildData.Player()
-	} else if len(guildData.Queue) < MAX_QUEUE_SIZE {
-		guildData.Queue <- play
 	}
 }
 
 func onReady(s *discordgo.Session, event *discordgo.Ready) {
 	log.Info("Received READY payload")
+	setGatewayReady(true)
 	s.UpdateStatus(0, fmt.Sprintf("with %d sounds", SoundCount))
 }
 
+func onGuildCreate(s *discordgo.Session, event *discordgo.GuildCreate) {
+	guild, _ := s.State.Guild(event.ID)
+	if guild == nil {
+		return
+	}
+	getGuild(guild).Restore()
+}
+
 func scontains(key string, options ...string) bool {
 	for _, item := range options {
 		if item == key {
@@ -276,7 +407,19 @@ func calculateAirhornsPerSecond(cid string) {
 	discord.ChannelMessageSend(cid, fmt.Sprintf("Current PPS: %v", (float64(latest-current))/10.0))
 }
 
-func displayBotStats(cid string) {
+// BotStats are the facts shown by the status command and the health endpoints
+type BotStats struct {
+	Discordgo        string `json:"discordgo"`
+	Go               string `json:"go"`
+	MemoryAlloc      uint64 `json:"memory_alloc"`
+	MemorySys        uint64 `json:"memory_sys"`
+	MemoryTotalAlloc uint64 `json:"memory_total_alloc"`
+	Tasks            int    `json:"tasks"`
+	Servers          int    `json:"servers"`
+	Users            int    `json:"users"`
+}
+
+func collectBotStats() BotStats {
 	stats := runtime.MemStats{}
 	runtime.ReadMemStats(&stats)
 
@@ -285,59 +428,68 @@ func displayBotStats(cid string) {
 		users += len(guild.Members)
 	}
 
+	return BotStats{
+		Discordgo:        discordgo.VERSION,
+		Go:               runtime.Version(),
+		MemoryAlloc:      stats.Alloc,
+		MemorySys:        stats.Sys,
+		MemoryTotalAlloc: stats.TotalAlloc,
+		Tasks:            runtime.NumGoroutine(),
+		Servers:          len(discord.State.Ready.Guilds),
+		Users:            users,
+	}
+}
+
+func displayBotStats(cid string) {
+	stats := collectBotStats()
+
 	w := &tabwriter.Writer{}
 	buf := &bytes.Buffer{}
 
 	w.Init(buf, 0, 4, 0, ' ', 0)
 	fmt.Fprintf(w, "```\n")
-	fmt.Fprintf(w, "Discordgo: \t%s\n", discordgo.VERSION)
-	fmt.Fprintf(w, "Go: \t%s\n", runtime.Version())
-	fmt.Fprintf(w, "Memory: \t%s / %s (%s total allocated)\n", humanize.Bytes(stats.Alloc), humanize.Bytes(stats.Sys), humanize.Bytes(stats.TotalAlloc))
-	fmt.Fprintf(w, "Tasks: \t%d\n", runtime.NumGoroutine())
-	fmt.Fprintf(w, "Servers: \t%d\n", len(discord.State.Ready.Guilds))
-	fmt.Fprintf(w, "Users: \t%d\n", users)
+	fmt.Fprintf(w, "Discordgo: \t%s\n", stats.Discordgo)
+	fmt.Fprintf(w, "Go: \t%s\n", stats.Go)
+	fmt.Fprintf(w, "Memory: \t%s / %s (%s total allocated)\n", humanize.Bytes(stats.MemoryAlloc), humanize.Bytes(stats.MemorySys), humanize.Bytes(stats.MemoryTotalAlloc))
+	fmt.Fprintf(w, "Tasks: \t%d\n", stats.Tasks)
+	fmt.Fprintf(w, "Servers: \t%d\n", stats.Servers)
+	fmt.Fprintf(w, "Users: \t%d\n", stats.Users)
 	fmt.Fprintf(w, "```\n")
 	w.Flush()
 	fmt.Println(discord.ChannelMessageSend(cid, buf.String()))
 }
 
-func utilSumRedisKeys(keys []string) int {
-	results := make([]*redis.StringCmd, 0)
+// Displays total plays and the most played clip from the totals and sorted set
+func displayTotals(cid string, label string, totalKey string, soundsKey string) {
+	var (
+		total *redis.StringCmd
+		top   *redis.ZSliceCmd
+	)
 
-	rcli.Pipelined(func(pipe *redis.Pipeline) error {
-		for _, key := range keys {
-			results = append(results, pipe.Get(key))
-		}
+	_, err := rcli.Pipelined(func(pipe *redis.Pipeline) error {
+		total = pipe.Get(totalKey)
+		top = pipe.ZRevRangeWithScores(soundsKey, 0, 0)
 		return nil
 	})
-
-	var total int
-	for _, i := range results {
-		t, _ := strconv.Atoi(i.Val())
-		total += t
+	if err != nil && err != redis.Nil {
+		return
 	}
 
-	return total
+	totalAirhorns, _ := strconv.Atoi(total.Val())
+	msg := fmt.Sprintf("%s: %v", label, totalAirhorns)
+	if len(top.Val()) > 0 {
+		z := top.Val()[0]
+		msg += fmt.Sprintf(", most played: %s (%d)", formatMember(nil, topClips, fmt.Sprint(z.Member)), int(z.Score))
+	}
+	discord.ChannelMessageSend(cid, msg)
 }
 
 func displayUserStats(cid, uid string) {
-	keys, err := rcli.Keys(fmt.Sprintf("niksibot:*:user:%s:sound:*", uid)).Result()
-	if err != nil {
-		return
-	}
-
-	totalAirhorns := utilSumRedisKeys(keys)
-	discord.ChannelMessageSend(cid, fmt.Sprintf("Total plays: %v", totalAirhorns))
+	displayTotals(cid, "Specific clips played", userTotalKey(uid), userSoundsKey(uid))
 }
 
 func displayServerStats(cid, sid string) {
-	keys, err := rcli.Keys(fmt.Sprintf("niksibot:*:guild:%s:sound:*", sid)).Result()
-	if err != nil {
-		return
-	}
-
-	totalAirhorns := utilSumRedisKeys(keys)
-	discord.ChannelMessageSend(cid, fmt.Sprintf("Total plays: %v", totalAirhorns))
+	displayTotals(cid, "Total plays", guildTotalKey(sid), leaderboardKey(sid, topClips, "all"))
 }
 
 func utilGetMentioned(s *discordgo.Session, m *discordgo.MessageCreate) *discordgo.User {
@@ -369,38 +521,29 @@ func handleBotControlMessages(s *discordgo.Session, m *discordgo.MessageCreate,
 
 func main() {
 	//log.SetLevel(log.DebugLevel)
-	const audioDir = "audio"
-	if _, err := os.Stat(audioDir); os.IsNotExist(err) {
-		log.Fatal("Audio directory does not exist.")
-		return
-	}
-
-	COLLECTIONS = discoverSounds(audioDir)
-
 	var (
-		Token      = flag.String("t", "", "Discord Authentication Token")
-		Redis      = flag.String("r", "", "Redis Connection String")
-		Shard      = flag.String("s", "", "Shard ID")
-		ShardCount = flag.String("c", "", "Number of shards")
-		Owner      = flag.String("o", "", "Owner ID")
-		err        error
+		ConfigPath   = flag.String("config", "config.yml", "Configuration file")
+		MigrateStats = flag.Bool("migrate-stats", false, "Backfill stats totals from old keys and exit")
+		err          error
 	)
 	flag.Parse()
 
-	if *Owner != "" {
-		OWNER = *Owner
+	config, err = loadConfig(*ConfigPath)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  *ConfigPath,
+			"error": err,
+		}).Fatal("Failed to load configuration")
+		return
 	}
+	c := getConfig()
 
-	// Preload all the sounds
-	log.Info("Preloading sounds...")
-	for _, coll := range COLLECTIONS {
-		coll.Load()
-	}
+	COLLECTIONS = discoverSounds(c.AudioDir)
 
 	// If we got passed a redis server, try to connect
-	if *Redis != "" {
+	if c.Redis.Addr != "" {
 		log.Info("Connecting to redis...")
-		rcli = redis.NewClient(&redis.Options{Addr: *Redis, DB: 0})
+		rcli = redis.NewClient(&redis.Options{Addr: c.Redis.Addr, Password: c.Redis.Password, DB: c.Redis.DB})
 		_, err = rcli.Ping().Result()
 
 		if err != nil {
@@ -411,9 +554,36 @@ func main() {
 		}
 	}
 
+	if *MigrateStats {
+		if rcli == nil {
+			log.Fatal("Redis is not configured, nothing to migrate")
+			return
+		}
+
+		log.Info("Migrating stats...")
+		if err = migrateStats(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("Failed to migrate stats")
+		}
+		return
+	}
+
+	// Preload all the sounds
+	log.Info("Preloading sounds...")
+	for _, coll := range COLLECTIONS {
+		coll.Load()
+	}
+	atomic.StoreInt32(&audioLoaded, 1)
+
+	loadIntros()
+	loadSettings()
+	loadUploads()
+	loadState()
+
 	// Create a discord session
 	log.Info("Starting discord session...")
-	discord, err = discordgo.New(*Token)
+	discord, err = discordgo.New(c.Token)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
@@ -424,15 +594,16 @@ func main() {
 	//discord.LogLevel = discordgo.LogDebug
 
 	// Set sharding info
-	discord.ShardID, _ = strconv.Atoi(*Shard)
-	discord.ShardCount, _ = strconv.Atoi(*ShardCount)
+	discord.ShardID = c.Shard
+	discord.ShardCount = c.ShardCount
 
-	if discord.ShardCount <= 0 {
-		discord.ShardCount = 1
-	}
+	// Health endpoints should answer while connecting too
+	startHTTP()
 
-	discord.AddHandler(onReady)
-	discord.AddHandler(onMessageCreate)
+	// Handlers are removed when shutting down, so no guilds or plays are added meanwhile
+	for _, handler := range []interface{}{onReady, onResumed, onDisconnect, onGuildCreate, onMessageCreate, onMessageReactionAdd, onVoiceStateUpdate} {
+		removeHandlers = append(removeHandlers, discord.AddHandler(handler))
+	}
 
 	err = discord.Open()
 	if err != nil {
@@ -445,8 +616,15 @@ func main() {
 	// We're running!
 	log.Info("The bot is ready.")
 
-	// Wait for a signal to quit
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
-	<-c
+	// Wait for a signal to quit, reload configuration on SIGHUP
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, os.Interrupt, os.Kill, syscall.SIGHUP)
+	for sig := range sc {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloadConfig(*ConfigPath)
+	}
+
+	shutdown()
 }
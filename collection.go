@@ -1,8 +1,13 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -16,12 +21,100 @@ type SoundCollection struct {
 	soundRange int
 }
 
+// Serializes changes to the collections and their sounds made while the bot is running.
+// Readers take the read lock, helpers used by both sides expect the caller to hold the lock.
+var soundsMutex sync.RWMutex
+
+// Returns the collections, the slice isn't changed afterwards so it can be used without the lock
+func allCollections() []*SoundCollection {
+	soundsMutex.RLock()
+	defer soundsMutex.RUnlock()
+	return COLLECTIONS
+}
+
+// Sound finds the sound with exactly the given name
+func (sc *SoundCollection) Sound(name string) *Sound {
+	soundsMutex.RLock()
+	defer soundsMutex.RUnlock()
+
+	for _, sound := range sc.Sounds {
+		if sound.Name == name {
+			return sound
+		}
+	}
+	return nil
+}
+
+// Remove the sound from the collection, the caller must hold soundsMutex.
+// Collections left without sounds are removed, like they would be on restart.
+func (sc *SoundCollection) Remove(sound *Sound) {
+	for i, s := range sc.Sounds {
+		if s == sound {
+			sc.Sounds = append(sc.Sounds[:i:i], sc.Sounds[i+1:]...)
+			sc.soundRange -= sound.Weight
+			SoundCount--
+			break
+		}
+	}
+
+	if len(sc.Sounds) > 0 {
+		return
+	}
+	for i, coll := range COLLECTIONS {
+		if coll == sc {
+			COLLECTIONS = append(COLLECTIONS[:i:i], COLLECTIONS[i+1:]...)
+			break
+		}
+	}
+}
+
+// Empty reports whether the collection has no sounds left to play
+func (sc *SoundCollection) Empty() bool {
+	soundsMutex.RLock()
+	defer soundsMutex.RUnlock()
+	return sc.soundRange <= 0
+}
+
+// Taken reports whether a sound in the collection already has the name or alias, the caller must hold soundsMutex
+func (sc *SoundCollection) Taken(name string) bool {
+	name = strings.ToLower(name)
+	for _, sound := range sc.Sounds {
+		if scontains(name, sound.Keys()...) {
+			return true
+		}
+	}
+	return false
+}
+
+// Duration of all sounds in the collection, the caller must hold soundsMutex
+func (sc *SoundCollection) Duration() time.Duration {
+	var total time.Duration
+	for _, sound := range sc.Sounds {
+		total += sound.Duration()
+	}
+	return total
+}
+
+// Add a sound to the collection, the caller must hold soundsMutex.
+// The sound is checked for silence again, as collections can have different silence modes.
+func (sc *SoundCollection) Add(sound *Sound) {
+	sound.Collection = sc
+	sound.setFrames(sound.file)
+	sc.Sounds = append(sc.Sounds, sound)
+	sc.soundRange += sound.Weight
+	SoundCount++
+}
+
 // Create a collection from each directory inside the given path
-func discoverSounds(path string) []*SoundCollection {
+func discoverSounds(root string) []*SoundCollection {
 	collections := []*SoundCollection{}
 
-	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() && info.Name() != path {
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && path != root {
 			sc := createCollection(info.Name(), path)
 			if sc != nil {
 				collections = append(collections, sc)
@@ -62,7 +155,11 @@ func createCollection(name string, path string) *SoundCollection {
 			name := info.Name()
 			extension := filepath.Ext(name)
 
-			sc.Sounds = append(sc.Sounds, createSound(name[0:len(name)-len(extension)], 1, 100, &sc))
+			base := path[0 : len(path)-len(extension)]
+			sound := createSound(name[0:len(name)-len(extension)], readWeight(base+".weight"), 100, &sc)
+			sound.Aliases = readAliases(base + ".aliases")
+			sound.ID = readSidecar(base + ".id")
+			sc.Sounds = append(sc.Sounds, sound)
 		}
 
 		return nil
@@ -79,3 +176,49 @@ func createCollection(name string, path string) *SoundCollection {
 	}).Debug("Collection created")
 	return &sc
 }
+
+// Read the contents of the sound's sidecar file, returns an empty string if there's no such file
+func readSidecar(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Write the sound's sidecar file, an empty value removes the file
+func writeSidecar(path string, value string) error {
+	if value == "" {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(value+"\n"), 0644)
+}
+
+// Read the sound's weight from its sidecar file, the default weight is 1
+func readWeight(path string) int {
+	weight, err := strconv.Atoi(readSidecar(path))
+	if err != nil || weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+// Read aliases from the sound's sidecar file, one alias per line
+func readAliases(path string) []string {
+	data := readSidecar(path)
+	if data == "" {
+		return nil
+	}
+
+	aliases := []string{}
+	for _, line := range strings.Split(data, "\n") {
+		if alias := strings.TrimSpace(line); alias != "" {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config holds the settings read from the configuration file
+type Config struct {
+	// Connection settings, changing these requires a restart
+	Token      string      `yaml:"token"`
+	Shard      int         `yaml:"shard"`
+	ShardCount int         `yaml:"shard_count"`
+	Redis      RedisConfig `yaml:"redis"`
+	AudioDir   string      `yaml:"audio_dir"`
+	DataDir    string      `yaml:"data_dir"`
+	HTTP       HTTPConfig  `yaml:"http"`
+
+	// Access tokens of the HTTP API, tokens can be changed with a reload
+	API APIConfig `yaml:"api"`
+
+	// Owner of the bot (Discord user ID)
+	Owner string `yaml:"owner"`
+
+	// Sound encoding settings
+	Bitrate int `yaml:"bitrate"`
+
+	// Silence detection when sounds are loaded
+	Silence SilenceConfig `yaml:"silence"`
+
+	// Playback settings
+	MaxQueueSize   int           `yaml:"max_queue_size"`
+	MaxHistorySize int           `yaml:"max_history_size"`
+	AloneTimeout   time.Duration `yaml:"alone_timeout"`
+	IntroCooldown  time.Duration `yaml:"intro_cooldown"`
+
+	// Mixing clips on top of the playing clip
+	Overlay OverlayConfig `yaml:"overlay"`
+
+	// Clip upload settings
+	Upload UploadConfig `yaml:"upload"`
+
+	// Shutdown settings
+	ShutdownTimeout     time.Duration `yaml:"shutdown_timeout"`
+	ShutdownFinishClips bool          `yaml:"shutdown_finish_clips"`
+
+	// Settings for guilds which haven't changed them with commands, and changes to them for some guilds
+	Defaults GuildSettings             `yaml:"defaults"`
+	Guilds   map[string]GuildOverrides `yaml:"guilds"`
+}
+
+// RedisConfig holds the stats backend settings, empty address disables stats
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int64  `yaml:"db"`
+}
+
+// HTTPConfig holds the settings of the HTTP server, empty address disables it
+type HTTPConfig struct {
+	Listen string `yaml:"listen"`
+}
+
+// APIConfig holds the access tokens of the HTTP API
+type APIConfig struct {
+	Tokens []APIToken `yaml:"tokens"`
+}
+
+// APIToken grants access to the HTTP API
+type APIToken struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+
+	// Discord user ID plays are attributed to
+	User string `yaml:"user"`
+
+	// If true, requests can name the user plays are attributed to, overriding User
+	Impersonate bool `yaml:"impersonate"`
+
+	// Guilds the token can control, empty for all guilds
+	Guilds []string `yaml:"guilds"`
+}
+
+// SilenceConfig controls the detection of silence at the start and the end of sounds
+type SilenceConfig struct {
+	// What to do with the silence: off, report or trim
+	Mode string `yaml:"mode"`
+
+	// Peak level in dBFS below which audio counts as silent, used when decoding
+	Threshold float64 `yaml:"threshold"`
+
+	// Decode the sounds for precise detection, otherwise only Opus silence frames are recognized by their size
+	Decode bool `yaml:"decode"`
+
+	// Modes of collections which don't use the default mode
+	Collections map[string]string `yaml:"collections"`
+}
+
+// OverlayConfig holds the settings of mixing clips on top of the playing clip
+type OverlayConfig struct {
+	// Longer clips are queued as usual
+	MaxLength time.Duration `yaml:"max_length"`
+
+	// Volume of the playing clip in percent while clips are mixed on top of it
+	Duck int `yaml:"duck"`
+}
+
+// UploadConfig holds the limits and permissions of clip uploads
+type UploadConfig struct {
+	MaxSize     int           `yaml:"max_size"`
+	MaxDuration time.Duration `yaml:"max_duration"`
+
+	// Users (Discord user IDs) allowed to upload besides server admins
+	Users []string `yaml:"users"`
+
+	// If set, everyone else can upload too, but their clips wait for approval by an admin
+	Approval bool `yaml:"approval"`
+}
+
+var (
+	// Currently active configuration, replaced as a whole on reload
+	config      = defaultConfig()
+	configMutex sync.RWMutex
+)
+
+// Configuration used for settings missing from the file
+func defaultConfig() *Config {
+	return &Config{
+		ShardCount:     1,
+		AudioDir:       "audio",
+		DataDir:        "data",
+		Bitrate:        128,
+		MaxQueueSize:   12,
+		MaxHistorySize: 500,
+		AloneTimeout:   time.Minute,
+		IntroCooldown:  2 * time.Minute,
+
+		Silence: SilenceConfig{
+			Mode:      silenceReport,
+			Threshold: -60,
+		},
+
+		Overlay: OverlayConfig{
+			MaxLength: 10 * time.Second,
+			Duck:      40,
+		},
+
+		Upload: UploadConfig{
+			MaxSize:     8 * 1024 * 1024,
+			MaxDuration: 30 * time.Second,
+		},
+
+		ShutdownTimeout: 10 * time.Second,
+	}
+}
+
+// Get the currently active configuration
+func getConfig() *Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return config
+}
+
+// Read the configuration file and apply environment variable overrides
+func loadConfig(path string) (*Config, error) {
+	c := defaultConfig()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, err
+	}
+
+	// Secrets can be kept out of the file
+	if token := os.Getenv("NIKSIBOT_TOKEN"); token != "" {
+		c.Token = token
+	}
+	if addr := os.Getenv("NIKSIBOT_REDIS_ADDR"); addr != "" {
+		c.Redis.Addr = addr
+	}
+	if password := os.Getenv("NIKSIBOT_REDIS_PASSWORD"); password != "" {
+		c.Redis.Password = password
+	}
+	if owner := os.Getenv("NIKSIBOT_OWNER"); owner != "" {
+		c.Owner = owner
+	}
+
+	// The follow policy can be turned off with "off" like with !follow
+	c.Defaults.normalize()
+	for id, o := range c.Guilds {
+		o.normalize()
+		c.Guilds[id] = o
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks the configuration, returning all problems at once
+func (c *Config) Validate() error {
+	problems := []string{}
+
+	if c.Token == "" {
+		problems = append(problems, "token is missing (set it in the file or NIKSIBOT_TOKEN)")
+	}
+	if c.ShardCount < 1 {
+		problems = append(problems, "shard_count must be at least 1")
+	}
+	if c.Shard < 0 || c.Shard >= c.ShardCount {
+		problems = append(problems, fmt.Sprintf("shard must be between 0 and %d", c.ShardCount-1))
+	}
+	if c.Redis.DB < 0 {
+		problems = append(problems, "redis.db can't be negative")
+	}
+	if info, err := os.Stat(c.AudioDir); err != nil || !info.IsDir() {
+		problems = append(problems, fmt.Sprintf("audio_dir \"%s\" is not a directory", c.AudioDir))
+	}
+	if c.DataDir == "" {
+		problems = append(problems, "data_dir is missing")
+	}
+	if c.Bitrate < 6 || c.Bitrate > 510 {
+		problems = append(problems, "bitrate must be between 6 and 510 kbps")
+	}
+	if !scontains(c.Silence.Mode, silenceOff, silenceReport, silenceTrim) {
+		problems = append(problems, "silence.mode must be off, report or trim")
+	}
+	for name, mode := range c.Silence.Collections {
+		if !scontains(mode, silenceOff, silenceReport, silenceTrim) {
+			problems = append(problems, fmt.Sprintf("silence.collections.%s must be off, report or trim", name))
+		}
+	}
+	if c.Silence.Threshold >= 0 {
+		problems = append(problems, "silence.threshold must be negative")
+	}
+	if c.MaxQueueSize < 1 {
+		problems = append(problems, "max_queue_size must be at least 1")
+	}
+	if c.MaxHistorySize < 1 {
+		problems = append(problems, "max_history_size must be at least 1")
+	}
+	if c.AloneTimeout < 0 {
+		problems = append(problems, "alone_timeout can't be negative")
+	}
+	if c.IntroCooldown < 0 {
+		problems = append(problems, "intro_cooldown can't be negative")
+	}
+	if c.Overlay.MaxLength <= 0 {
+		problems = append(problems, "overlay.max_length must be positive")
+	}
+	if c.Overlay.Duck < 0 || c.Overlay.Duck > 100 {
+		problems = append(problems, "overlay.duck must be between 0 and 100")
+	}
+	if c.Upload.MaxSize <= 0 {
+		problems = append(problems, "upload.max_size must be positive")
+	}
+	if c.Upload.MaxDuration <= 0 {
+		problems = append(problems, "upload.max_duration must be positive")
+	}
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, "shutdown_timeout must be positive")
+	}
+
+	tokens := map[string]bool{}
+	for i, t := range c.API.Tokens {
+		if t.Token == "" {
+			problems = append(problems, fmt.Sprintf("api.tokens[%d]: token is missing", i))
+		} else if tokens[t.Token] {
+			problems = append(problems, fmt.Sprintf("api.tokens[%d]: token is used twice", i))
+		}
+		tokens[t.Token] = true
+	}
+
+	if err := c.Defaults.Validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("defaults: %v", err))
+	}
+	for id := range c.Guilds {
+		if err := c.GuildDefaults(id).Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("guilds.%s: %v", id, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// GuildDefaults returns the configured settings for the guild, the defaults with the guild's block on top
+func (c *Config) GuildDefaults(guildID string) GuildSettings {
+	if o, ok := c.Guilds[guildID]; ok {
+		return o.Apply(c.Defaults)
+	}
+	return c.Defaults
+}
+
+// Reload the configuration file, keeping the connection settings of the running bot
+func reloadConfig(path string) {
+	c, err := loadConfig(path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to reload configuration, keeping the old one")
+		return
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	if c.Token != config.Token || c.Shard != config.Shard || c.ShardCount != config.ShardCount ||
+		c.Redis != config.Redis || c.AudioDir != config.AudioDir || c.DataDir != config.DataDir ||
+		c.HTTP != config.HTTP {
+		log.Warning("Connection settings have changed, restart the bot to apply them")
+	}
+
+	c.Token = config.Token
+	c.Shard = config.Shard
+	c.ShardCount = config.ShardCount
+	c.Redis = config.Redis
+	c.AudioDir = config.AudioDir
+	c.DataDir = config.DataDir
+	c.HTTP = config.HTTP
+	config = c
+
+	log.Info("Configuration reloaded")
+}
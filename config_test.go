@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestGuildDefaults(t *testing.T) {
+	c := defaultConfig()
+	err := yaml.UnmarshalStrict([]byte(`
+defaults:
+  follow: requester
+  volume: 80
+  overlay: true
+  crossfade: 2s
+guilds:
+  "1":
+    follow: off
+  "2":
+    volume: 120
+    overlay: false
+`), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, o := range c.Guilds {
+		o.normalize()
+		c.Guilds[id] = o
+	}
+
+	tests := []struct {
+		guild     string
+		follow    string
+		volume    int
+		overlay   bool
+		crossfade time.Duration
+	}{
+		// Guilds without a block get the defaults, blocks only change the settings they set
+		{"0", followRequester, 80, true, 2 * time.Second},
+		{"1", followOff, 80, true, 2 * time.Second},
+		{"2", followRequester, 120, false, 2 * time.Second},
+	}
+
+	for _, test := range tests {
+		s := c.GuildDefaults(test.guild)
+		if s.Follow != test.follow || s.Volume == nil || *s.Volume != test.volume || s.Overlay != test.overlay || s.Crossfade != test.crossfade {
+			t.Errorf("GuildDefaults(%s) = %+v (volume %v), want follow %q, volume %d, overlay %v, crossfade %s",
+				test.guild, s, s.Volume, test.follow, test.volume, test.overlay, test.crossfade)
+		}
+	}
+
+	// Command changes go on top of the guild's block
+	crossfade := time.Duration(0)
+	if s := (&GuildOverrides{Crossfade: &crossfade}).Apply(c.GuildDefaults("2")); s.Crossfade != 0 || *s.Volume != 120 {
+		t.Errorf("Apply() = %+v, want no crossfade and volume 120", s)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Longest crossfade between clips
+const MAX_CROSSFADE = 10 * time.Second
+
+// Crossfade is the start of the next play, faded in over the end of the playing clip
+type Crossfade struct {
+	Play *Play
+
+	// Frames of the next play, how many of them have been mixed in and how many are faded in
+	frames [][]byte
+	pos    int
+	length int
+
+	transcoder *Transcoder
+}
+
+// Starts crossfading to the next play when the playing clip has as many frames left as the crossfade lasts.
+// Crossfades are only used if the next play is queued for the same channel and has no effects.
+func (g *Guild) startCrossfade(remaining int) *Crossfade {
+	if g == nil || g.NowPlaying() == nil || g.VoiceConnection == nil || g.SkipPending || g.DisconnectPending {
+		return nil
+	}
+
+	length := int(g.Settings().Crossfade / FRAME_DURATION)
+	if length <= 0 || remaining != length {
+		return nil
+	}
+
+	// In RNG4EVER mode random clips are normally picked after the playing clip ends,
+	// here the next one is needed early
+	if len(g.Queued()) <= 0 {
+		if g.State != RNG4EVER {
+			return nil
+		}
+
+		playing := g.NowPlaying()
+		play := createPlay(playing.User, g.Guild, playing.Sound.Collection, nil)
+		if play == nil {
+			return nil
+		}
+		g.Enqueue(play)
+		saveState()
+	}
+
+	transcoder, err := newTranscoder("crossfade")
+	if err != nil {
+		return nil
+	}
+
+	channelID := g.VoiceConnection.ChannelID
+	play := g.TakeNext(func(next *Play) bool {
+		if !next.Effects.None() || len(next.Sound.Frames()) <= length {
+			return false
+		}
+		channel := g.ResolveChannel(next)
+		if channel == nil || channel.ID != channelID {
+			return false
+		}
+		next.Channel = channel
+		return true
+	})
+	if play == nil {
+		return nil
+	}
+
+	g.crossfade = &Crossfade{
+		Play:       play,
+		frames:     play.Sound.Frames(),
+		length:     length,
+		transcoder: transcoder,
+	}
+	return g.crossfade
+}
+
+// Records the play taken for the crossfade when the player stops before getting to it.
+// Part of it was already heard, so it's saved in history as skipped.
+func (g *Guild) dropCrossfade() {
+	fade := g.crossfade
+	g.crossfade = nil
+	if fade == nil {
+		return
+	}
+
+	fade.Play.Skipped = true
+	g.SaveToHistory(fade.Play)
+	g.saveHistory()
+	publishPlay(eventSkipped, fade.Play)
+}
+
+// Mixes the next frame of the next play into a frame of the playing clip, fading the playing clip out
+func (f *Crossfade) mix(pcm []int16) {
+	if f.pos >= f.length {
+		return
+	}
+
+	// A frame that fails to decode is left out of the mix
+	next, err := f.transcoder.Decode(f.frames[f.pos])
+	if err != nil {
+		next = nil
+	}
+
+	samples := len(pcm) / AUDIO_CHANNELS
+	for i := 0; i < samples; i++ {
+		// Equal power curves keep the loudness steady through the crossfade
+		t := (float64(f.pos) + float64(i)/float64(samples)) / float64(f.length)
+		out, in := math.Cos(t*math.Pi/2), math.Sin(t*math.Pi/2)
+
+		for c := 0; c < AUDIO_CHANNELS; c++ {
+			j := i*AUDIO_CHANNELS + c
+			sample := float64(pcm[j]) * out
+			if j < len(next) {
+				sample += float64(next[j]) * in
+			}
+			pcm[j] = clampSample(sample)
+		}
+	}
+	f.pos++
+}
+
+// Frames of the next play left after the crossfade
+func (f *Crossfade) rest() [][]byte {
+	return f.frames[f.pos:]
+}
+
+// Handles the !crossfade [SECONDS|off] command
+func handleCrossfadeCommand(m *discordgo.MessageCreate, parts []string, guild *discordgo.Guild) {
+	usage := fmt.Sprintf("Usage: `!crossfade SECONDS|off`, up to %s", MAX_CROSSFADE)
+	if len(parts) < 2 {
+		state := "off"
+		if crossfade := getGuild(guild).Settings().Crossfade; crossfade > 0 {
+			state = crossfade.String()
+		}
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Crossfade is %s. %s", state, usage))
+		return
+	}
+
+	crossfade := time.Duration(0)
+	if parts[1] != "off" {
+		d, err := parseClipTime(parts[1])
+		if err != nil || d > MAX_CROSSFADE {
+			discord.ChannelMessageSend(m.ChannelID, usage)
+			return
+		}
+		crossfade = d
+	}
+
+	if !isGuildAdmin(m.Author.ID, m.ChannelID) {
+		discord.ChannelMessageSend(m.ChannelID, "Only server admins can change the crossfade.")
+		return
+	}
+
+	settingsMutex.Lock()
+	s := getSettings(guild.ID)
+	s.Crossfade = &crossfade
+	saveSettings()
+	settingsMutex.Unlock()
+
+	if crossfade <= 0 {
+		discord.ChannelMessageSend(m.ChannelID, "Crossfade is now off.")
+		return
+	}
+	discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Crossfade is now %s.", crossfade))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Longest time accepted in clip edits, clips are far shorter
+const MAX_CLIP_TIME = time.Hour
+
+// Parses a position in a clip, given in seconds ("1.5"), as a duration ("1500ms") or in minutes and seconds ("1:02.5")
+func parseClipTime(value string) (time.Duration, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 || d > MAX_CLIP_TIME {
+			return 0, fmt.Errorf("time `%s` must be between 0 and %s", value, MAX_CLIP_TIME)
+		}
+		return d, nil
+	}
+	original := value
+
+	minutes := 0
+	if i := strings.Index(value, ":"); i >= 0 {
+		m, err := strconv.Atoi(value[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid time `%s`", value)
+		}
+		minutes = m
+		value = value[i+1:]
+	}
+
+	// ParseFloat also takes inf and nan, they are left out with the range check
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(seconds) || seconds < 0 || minutes < 0 {
+		return 0, fmt.Errorf("invalid time `%s`", original)
+	}
+	if float64(minutes)*60+seconds > MAX_CLIP_TIME.Seconds() {
+		return 0, fmt.Errorf("time `%s` must be between 0 and %s", original, MAX_CLIP_TIME)
+	}
+	return time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second)), nil
+}
+
+// Fades the interleaved PCM in and out linearly
+func applyFades(pcm []int16, fadeIn time.Duration, fadeOut time.Duration) {
+	in := samplesIn(fadeIn)
+	out := samplesIn(fadeOut)
+	total := len(pcm) / AUDIO_CHANNELS
+
+	for i := 0; i < total; i++ {
+		gain := 1.0
+		if i < in {
+			gain = float64(i) / float64(in)
+		}
+		if left := total - 1 - i; left < out && float64(left)/float64(out) < gain {
+			gain = float64(left) / float64(out)
+		}
+		if gain >= 1 {
+			continue
+		}
+
+		for c := 0; c < AUDIO_CHANNELS; c++ {
+			pcm[i*AUDIO_CHANNELS+c] = int16(float64(pcm[i*AUDIO_CHANNELS+c]) * gain)
+		}
+	}
+}
+
+// Copies the sound's file aside before the first edit, so the edits can be undone
+func keepOriginal(sound *Sound) error {
+	if _, err := os.Stat(sound.sidecarPath(".orig")); err == nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(sound.Path())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(sound.sidecarPath(".orig"), data, 0644)
+}
+
+// Trims the sound's file to the part between start and end and fades it in and out, a negative end is the end
+// of the file. Trimming drops whole frames, fades need the audio to be decoded and encoded again.
+func editSound(sound *Sound, start time.Duration, end time.Duration, fadeIn time.Duration, fadeOut time.Duration) error {
+	soundsMutex.Lock()
+	defer soundsMutex.Unlock()
+
+	// Times are measured in the file, silence trimmed when loading doesn't count
+	first := int(start / FRAME_DURATION)
+	last := len(sound.file)
+	if end >= 0 {
+		last = minInt(int((end+FRAME_DURATION-1)/FRAME_DURATION), last)
+	}
+	if start < 0 || first >= last {
+		return errors.New("nothing would be left of the clip")
+	}
+
+	frames := append([][]byte{}, sound.file[first:last]...)
+	if fadeIn > 0 || fadeOut > 0 {
+		pcm, err := decodeOpus(frames)
+		if err != nil {
+			return err
+		}
+
+		applyFades(pcm, fadeIn, fadeOut)
+		if frames, err = encodeOpus(pcm); err != nil {
+			return err
+		}
+	}
+
+	if err := keepOriginal(sound); err != nil {
+		return err
+	}
+	if err := writeDCA(sound.Path(), frames); err != nil {
+		return err
+	}
+	sound.setFrames(frames)
+
+	log.WithFields(log.Fields{
+		"collection": sound.Collection.Prefix,
+		"sound":      sound.Name,
+		"duration":   sound.Duration(),
+	}).Info("Sound edited")
+	return nil
+}
+
+// Restores the sound as it was before it was first edited
+func undoSoundEdits(sound *Sound) error {
+	soundsMutex.Lock()
+	defer soundsMutex.Unlock()
+
+	original := sound.sidecarPath(".orig")
+	frames, err := readDCA(original)
+	if os.IsNotExist(err) {
+		return errors.New("the clip hasn't been edited")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(original, sound.Path()); err != nil {
+		return err
+	}
+	sound.setFrames(frames)
+	return nil
+}
+
+// Handles the !trim command
+func handleTrimCommand(m *discordgo.MessageCreate, parts []string) {
+	usage := "Usage: `!trim <COLLECTION> <CLIP> <START> <END|end> [FADE_IN] [FADE_OUT]` or `!trim undo <COLLECTION> <CLIP>`"
+	if len(parts) < 4 {
+		discord.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+
+	// Collections are shared by all guilds, so only the owner can change them
+	if m.Author.ID != getConfig().Owner {
+		discord.ChannelMessageSend(m.ChannelID, "Only the bot owner can edit clips.")
+		return
+	}
+
+	if parts[1] == "undo" {
+		sound := findExactClip(m.ChannelID, parts[2], parts[3])
+		if sound == nil {
+			return
+		}
+
+		if err := undoSoundEdits(sound); err != nil {
+			discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Failed: %v", err))
+			return
+		}
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Restored the original `!%s %s` (%s).", sound.Collection.Prefix, sound.Name, formatDuration(sound.Length())))
+		return
+	}
+
+	if len(parts) < 5 || len(parts) > 7 {
+		discord.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+
+	sound := findExactClip(m.ChannelID, parts[1], parts[2])
+	if sound == nil {
+		return
+	}
+
+	// Times default to the whole clip without fades
+	times := []time.Duration{0, -1, 0, 0}
+	for i, value := range parts[3:] {
+		if i == 1 && value == "end" {
+			continue
+		}
+
+		t, err := parseClipTime(value)
+		if err != nil {
+			discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%v, %s", err, usage))
+			return
+		}
+		times[i] = t
+	}
+
+	if err := editSound(sound, times[0], times[1], times[2], times[3]); err != nil {
+		log.WithFields(log.Fields{
+			"sound": sound.Name,
+			"error": err,
+		}).Warning("Failed to edit clip")
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Failed: %v", err))
+		return
+	}
+	discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("`!%s %s` is now %s long, undo with `!trim undo %s %s`.",
+		sound.Collection.Prefix, sound.Name, formatDuration(sound.Length()), sound.Collection.Prefix, sound.Name))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseClipTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{"0", 0, false},
+		{"1.5", 1500 * time.Millisecond, false},
+		{"1500ms", 1500 * time.Millisecond, false},
+		{"2s", 2 * time.Second, false},
+		{"1:02.5", time.Minute + 2500*time.Millisecond, false},
+		{"0:30", 30 * time.Second, false},
+		{"-1", 0, true},
+		{"-1s", 0, true},
+		{"-1:00", 0, true},
+		{"1:-5", 0, true},
+		{"x:10", 0, true},
+		{"soon", 0, true},
+		{"", 0, true},
+		{"1:00:00", 0, true},
+		{"60:00", time.Hour, false},
+		{"60:01", 0, true},
+		{"3601", 0, true},
+		{"2h", 0, true},
+		{"inf", 0, true},
+		{"+Inf", 0, true},
+		{"nan", 0, true},
+		{"1e300", 0, true},
+		{"99999999999", 0, true},
+		{"99999999999:00", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseClipTime(test.value)
+		if (err != nil) != test.err {
+			t.Errorf("parseClipTime(%q) error = %v, want error %v", test.value, err, test.err)
+			continue
+		}
+		if !test.err && got != test.want {
+			t.Errorf("parseClipTime(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestEditSoundBounds(t *testing.T) {
+	sound := &Sound{Name: "test", file: make([][]byte, 10)}
+
+	// None of these leave anything, so the file is never touched
+	for _, times := range [][2]time.Duration{
+		{time.Second, -1},
+		{-time.Second, -1},
+		{100 * time.Millisecond, 100 * time.Millisecond},
+		{100 * time.Millisecond, 20 * time.Millisecond},
+		{MAX_CLIP_TIME, MAX_CLIP_TIME},
+	} {
+		if err := editSound(sound, times[0], times[1], 0, 0); err == nil {
+			t.Errorf("editSound(%s, %s) of a 200ms clip succeeded", times[0], times[1])
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Effect settings
+const (
+	// Speed factors of --fast and --slow
+	FAST_SPEED = 1.5
+	SLOW_SPEED = 0.75
+
+	// Largest pitch shift in semitones
+	MAX_PITCH = 12
+
+	// Longest clip (with the effects applied) effects can be used on, the whole clip is rendered in memory
+	MAX_EFFECTS_LENGTH = 60 * time.Second
+
+	// Length of the grains overlapped when stretching audio, in samples per channel (about 40ms)
+	STRETCH_GRAIN_SIZE = 2048
+)
+
+// Effects change how a clip sounds when it's played
+type Effects struct {
+	// Playback speed, above 1 is faster and higher, below 1 slower and lower. Zero means normal speed.
+	Speed float64
+
+	// Pitch shift in semitones, the length of the clip stays the same
+	Pitch int
+
+	// Play the clip backwards
+	Reverse bool
+}
+
+// None reports whether the clip is played as it is
+func (e Effects) None() bool {
+	return (e.Speed == 0 || e.Speed == 1) && e.Pitch == 0 && !e.Reverse
+}
+
+// Scale returns how many times longer the clip gets with the effects
+func (e Effects) Scale() float64 {
+	if e.Speed == 0 {
+		return 1
+	}
+	return 1 / e.Speed
+}
+
+// Fits reports whether the effects can be applied to a clip of the given number of frames
+func (e Effects) Fits(frames int) bool {
+	return time.Duration(float64(frames)*e.Scale())*FRAME_DURATION <= MAX_EFFECTS_LENGTH
+}
+
+// String formats the effects as command options, like "--fast --pitch +3"
+func (e Effects) String() string {
+	options := []string{}
+	switch e.Speed {
+	case FAST_SPEED:
+		options = append(options, "--fast")
+	case SLOW_SPEED:
+		options = append(options, "--slow")
+	}
+	if e.Pitch != 0 {
+		options = append(options, fmt.Sprintf("--pitch %+d", e.Pitch))
+	}
+	if e.Reverse {
+		options = append(options, "--reverse")
+	}
+	return strings.Join(options, " ")
+}
+
+// Takes effect options out of the command's arguments, returning the effects and the remaining arguments
+func parseEffects(args []string) (Effects, []string, error) {
+	effects := Effects{}
+	rest := []string{}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--fast":
+			effects.Speed = FAST_SPEED
+		case "--slow":
+			effects.Speed = SLOW_SPEED
+		case "--reverse":
+			effects.Reverse = true
+		case "--pitch":
+			pitch := 0
+			if i+1 < len(args) {
+				pitch, _ = strconv.Atoi(args[i+1])
+				i++
+			}
+			if pitch == 0 || pitch < -MAX_PITCH || pitch > MAX_PITCH {
+				return effects, nil, fmt.Errorf("--pitch needs a number of semitones from -%d to +%d", MAX_PITCH, MAX_PITCH)
+			}
+			effects.Pitch = pitch
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return effects, nil, fmt.Errorf("unknown effect `%s`, try --fast, --slow, --reverse or --pitch", args[i])
+			}
+			rest = append(rest, args[i])
+		}
+	}
+	return effects, rest, nil
+}
+
+// Render the frames with the effects applied, the whole clip is decoded and encoded again
+func (e Effects) Render(frames [][]byte) ([][]byte, error) {
+	if !e.Fits(len(frames)) {
+		return nil, errors.New("clip is too long for effects")
+	}
+
+	transcoder, err := newTranscoder("effects")
+	if err != nil {
+		return nil, err
+	}
+
+	pcm := make([]int16, 0, len(frames)*AUDIO_FRAME_SIZE*AUDIO_CHANNELS)
+	for _, frame := range frames {
+		decoded, err := transcoder.Decode(frame)
+		if err != nil {
+			return nil, err
+		}
+		pcm = append(pcm, decoded...)
+	}
+
+	if e.Pitch != 0 {
+		// Resampling raises the pitch and shortens the clip, stretching restores the length
+		factor := math.Pow(2, float64(e.Pitch)/12)
+		pcm = timeStretch(resample(pcm, factor), factor)
+	}
+	if e.Speed != 0 && e.Speed != 1 {
+		pcm = resample(pcm, e.Speed)
+	}
+	if e.Reverse {
+		reverse(pcm)
+	}
+
+	frameLength := AUDIO_FRAME_SIZE * AUDIO_CHANNELS
+	rendered := [][]byte{}
+	for i := 0; i < len(pcm); i += frameLength {
+		frame := make([]int16, frameLength)
+		copy(frame, pcm[i:minInt(i+frameLength, len(pcm))])
+
+		opus, err := transcoder.Encode(frame)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, opus)
+	}
+
+	metricTranscodedFrames.WithLabelValues("effects").Add(float64(len(frames)))
+	return rendered, nil
+}
+
+// Resamples the audio to play faster by the factor, which also changes the pitch like a sped up tape
+func resample(pcm []int16, factor float64) []int16 {
+	samples := len(pcm) / AUDIO_CHANNELS
+	length := int(float64(samples) / factor)
+	out := make([]int16, length*AUDIO_CHANNELS)
+
+	for i := 0; i < length; i++ {
+		// Linear interpolation between the nearest input samples
+		pos := float64(i) * factor
+		j := int(pos)
+		frac := pos - float64(j)
+		for c := 0; c < AUDIO_CHANNELS; c++ {
+			a := float64(pcm[j*AUDIO_CHANNELS+c])
+			b := a
+			if j+1 < samples {
+				b = float64(pcm[(j+1)*AUDIO_CHANNELS+c])
+			}
+			out[i*AUDIO_CHANNELS+c] = clampSample(a + (b-a)*frac)
+		}
+	}
+	return out
+}
+
+// Makes the audio longer by the factor without changing its pitch, by overlapping windowed grains
+func timeStretch(pcm []int16, factor float64) []int16 {
+	samples := len(pcm) / AUDIO_CHANNELS
+	length := int(float64(samples) * factor)
+	out := make([]float64, length*AUDIO_CHANNELS)
+
+	// Hann windows overlapping by half sum up to one
+	window := make([]float64, STRETCH_GRAIN_SIZE)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/STRETCH_GRAIN_SIZE)
+	}
+
+	hopOut := STRETCH_GRAIN_SIZE / 2
+	hopIn := float64(hopOut) / factor
+	for k := 0; k*hopOut < length; k++ {
+		in := int(float64(k) * hopIn)
+		for i := 0; i < STRETCH_GRAIN_SIZE && k*hopOut+i < length && in+i < samples; i++ {
+			for c := 0; c < AUDIO_CHANNELS; c++ {
+				out[(k*hopOut+i)*AUDIO_CHANNELS+c] += window[i] * float64(pcm[(in+i)*AUDIO_CHANNELS+c])
+			}
+		}
+	}
+
+	result := make([]int16, len(out))
+	for i, sample := range out {
+		result[i] = clampSample(sample)
+	}
+	return result
+}
+
+// Reverses the audio in place, keeping the channels in place
+func reverse(pcm []int16) {
+	samples := len(pcm) / AUDIO_CHANNELS
+	for i, j := 0, samples-1; i < j; i, j = i+1, j-1 {
+		for c := 0; c < AUDIO_CHANNELS; c++ {
+			pcm[i*AUDIO_CHANNELS+c], pcm[j*AUDIO_CHANNELS+c] = pcm[j*AUDIO_CHANNELS+c], pcm[i*AUDIO_CHANNELS+c]
+		}
+	}
+}
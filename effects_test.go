@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEffects(t *testing.T) {
+	tests := []struct {
+		args    []string
+		effects Effects
+		rest    []string
+		err     bool
+	}{
+		{[]string{}, Effects{}, []string{}, false},
+		{[]string{"airhorn"}, Effects{}, []string{"airhorn"}, false},
+		{[]string{"--fast", "air", "horn"}, Effects{Speed: FAST_SPEED}, []string{"air", "horn"}, false},
+		{[]string{"airhorn", "--slow", "--reverse"}, Effects{Speed: SLOW_SPEED, Reverse: true}, []string{"airhorn"}, false},
+		{[]string{"--pitch", "+3", "airhorn"}, Effects{Pitch: 3}, []string{"airhorn"}, false},
+		{[]string{"--pitch", "-12"}, Effects{Pitch: -12}, []string{}, false},
+		{[]string{"--pitch", "13"}, Effects{}, nil, true},
+		{[]string{"--pitch", "0"}, Effects{}, nil, true},
+		{[]string{"--pitch"}, Effects{}, nil, true},
+		{[]string{"--pitch", "high"}, Effects{}, nil, true},
+		{[]string{"--loud"}, Effects{}, nil, true},
+	}
+
+	for _, test := range tests {
+		effects, rest, err := parseEffects(test.args)
+		if (err != nil) != test.err {
+			t.Errorf("parseEffects(%q) error = %v, want error %v", test.args, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if effects != test.effects || !reflect.DeepEqual(rest, test.rest) {
+			t.Errorf("parseEffects(%q) = %+v, %q, want %+v, %q", test.args, effects, rest, test.effects, test.rest)
+		}
+	}
+}
+
+func TestEffectsString(t *testing.T) {
+	tests := []struct {
+		effects Effects
+		want    string
+	}{
+		{Effects{}, ""},
+		{Effects{Speed: FAST_SPEED}, "--fast"},
+		{Effects{Speed: SLOW_SPEED, Pitch: -2, Reverse: true}, "--slow --pitch -2 --reverse"},
+		{Effects{Pitch: 5}, "--pitch +5"},
+	}
+
+	for _, test := range tests {
+		if got := test.effects.String(); got != test.want {
+			t.Errorf("%+v.String() = %q, want %q", test.effects, got, test.want)
+		}
+
+		// The formatted effects are saved in the queue state and parsed again on restore
+		parsed, _, err := parseEffects(strings.Fields(test.want))
+		if err != nil || parsed != test.effects {
+			t.Errorf("parsing %q = %+v, %v, want %+v", test.want, parsed, err, test.effects)
+		}
+	}
+}
+
+func TestEffectsScale(t *testing.T) {
+	tests := []struct {
+		effects Effects
+		scale   float64
+		fits    int
+	}{
+		{Effects{}, 1, 3000},
+		{Effects{Pitch: 4, Reverse: true}, 1, 3000},
+		{Effects{Speed: FAST_SPEED}, 1 / FAST_SPEED, 4500},
+		{Effects{Speed: SLOW_SPEED}, 1 / SLOW_SPEED, 2250},
+	}
+
+	for _, test := range tests {
+		if got := test.effects.Scale(); got != test.scale {
+			t.Errorf("%+v.Scale() = %v, want %v", test.effects, got, test.scale)
+		}
+		if !test.effects.Fits(test.fits) || test.effects.Fits(test.fits+2) {
+			t.Errorf("%+v.Fits() should allow up to %d frames", test.effects, test.fits)
+		}
+	}
+}
+
+func TestResample(t *testing.T) {
+	tests := []struct {
+		pcm    []int16
+		factor float64
+		want   []int16
+	}{
+		{[]int16{0, 0, 10, -10, 20, -20, 30, -30}, 1, []int16{0, 0, 10, -10, 20, -20, 30, -30}},
+		{[]int16{0, 0, 10, -10, 20, -20, 30, -30}, 2, []int16{0, 0, 20, -20}},
+		{[]int16{0, 0, 10, -10}, 0.5, []int16{0, 0, 5, -5, 10, -10, 10, -10}},
+		{[]int16{}, 1.5, []int16{}},
+	}
+
+	for _, test := range tests {
+		if got := resample(test.pcm, test.factor); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("resample(%v, %v) = %v, want %v", test.pcm, test.factor, got, test.want)
+		}
+	}
+}
+
+func TestTimeStretchLength(t *testing.T) {
+	pcm := make([]int16, 4800*AUDIO_CHANNELS)
+	for _, factor := range []float64{0.5, 1, 1.5, 2} {
+		got := len(timeStretch(pcm, factor))
+		if want := int(4800*factor) * AUDIO_CHANNELS; got != want {
+			t.Errorf("timeStretch(%v) gave %d samples, want %d", factor, got, want)
+		}
+	}
+}
+
+func TestReverse(t *testing.T) {
+	tests := []struct {
+		pcm  []int16
+		want []int16
+	}{
+		{[]int16{}, []int16{}},
+		{[]int16{1, 2}, []int16{1, 2}},
+		{[]int16{1, 2, 3, 4}, []int16{3, 4, 1, 2}},
+		{[]int16{1, 2, 3, 4, 5, 6}, []int16{5, 6, 3, 4, 1, 2}},
+	}
+
+	for _, test := range tests {
+		pcm := append([]int16{}, test.pcm...)
+		reverse(pcm)
+		if !reflect.DeepEqual(pcm, test.want) {
+			t.Errorf("reverse(%v) = %v, want %v", test.pcm, pcm, test.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Playback event types
+const (
+	eventEnqueued     = "enqueued"
+	eventStarted      = "started"
+	eventSkipped      = "skipped"
+	eventFinished     = "finished"
+	eventDisconnected = "disconnected"
+	eventModeChanged  = "mode_changed"
+)
+
+// Number of events buffered per subscriber, events are dropped for subscribers that fall behind
+const EVENT_BUFFER_SIZE = 64
+
+// Interval of comments sent to keep idle event streams open through proxies
+const EVENT_KEEPALIVE = 30 * time.Second
+
+// Event is a playback event of a guild
+type Event struct {
+	Type      string    `json:"type"`
+	Guild     string    `json:"guild"`
+	Time      time.Time `json:"time"`
+	Play      *PlayRef  `json:"play,omitempty"`
+	SkippedBy string    `json:"skipped_by,omitempty"`
+	Mode      string    `json:"mode,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+var (
+	// Subscribed channels and the filters of the events they want
+	subscribers      = make(map[chan Event]func(Event) bool)
+	subscribersMutex sync.Mutex
+)
+
+// Subscribe to the events accepted by the filter
+func subscribe(filter func(Event) bool) chan Event {
+	ch := make(chan Event, EVENT_BUFFER_SIZE)
+
+	subscribersMutex.Lock()
+	subscribers[ch] = filter
+	subscribersMutex.Unlock()
+	return ch
+}
+
+func unsubscribe(ch chan Event) {
+	subscribersMutex.Lock()
+	delete(subscribers, ch)
+	subscribersMutex.Unlock()
+}
+
+// Publish the event to all subscribers without waiting for them
+func publish(event Event) {
+	event.Time = time.Now()
+
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+
+	for ch, filter := range subscribers {
+		if !filter(event) {
+			continue
+		}
+
+		select {
+		case ch <- event:
+		default:
+			log.WithFields(log.Fields{
+				"type":  event.Type,
+				"guild": event.Guild,
+			}).Debug("Event subscriber is falling behind, dropping event")
+		}
+	}
+}
+
+// Publish an event about the play
+func publishPlay(eventType string, play *Play) {
+	ref := refPlay(play)
+	event := Event{
+		Type:  eventType,
+		Guild: play.Guild.Guild.ID,
+		Play:  &ref,
+	}
+	if play.SkippedBy != nil {
+		event.SkippedBy = play.SkippedBy.ID
+	}
+	publish(event)
+}
+
+// GET /api/events streams the events of the guilds the token can control as Server-Sent Events,
+// limited to one guild with the guild parameter
+func apiEvents(w http.ResponseWriter, r *http.Request, token *APIToken) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	guildID := r.URL.Query().Get("guild")
+	if guildID != "" && !token.Allows(guildID) {
+		writeError(w, http.StatusForbidden, "token can't control this guild")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	// The token is looked up again for every event, as it may have been changed or removed
+	// by reloading the configuration while the stream is open
+	ch := subscribe(func(event Event) bool {
+		current := findToken(token.Token)
+		if current == nil || !current.Allows(event.Guild) {
+			return false
+		}
+		return guildID == "" || event.Guild == guildID
+	})
+	defer unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(EVENT_KEEPALIVE)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case event := <-ch:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		case <-keepalive.C:
+			// Streams of removed tokens are closed
+			if findToken(token.Token) == nil {
+				return
+			}
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
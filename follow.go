@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Follow policies decide which channel a play goes to
+const (
+	// Play in the channel the requester was in when the play was queued
+	followOff = ""
+	// Play in the channel the requester is in at play time
+	followRequester = "requester"
+	// Stay in the channel the bot is already in
+	followBot = "bot"
+	// Always play in the guild's home channel
+	followHome = "home"
+)
+
+// ResolveChannel picks the channel for the play according to the guild's follow policy.
+// Plays with an explicitly picked channel keep it.
+// Returns nil if the play should be skipped because the requester has left voice.
+func (g *Guild) ResolveChannel(play *Play) *discordgo.Channel {
+	s := g.Settings()
+	if play.Pinned || s.Follow == followOff {
+		return play.Channel
+	}
+
+	current := getCurrentVoiceChannel(play.User, g.Guild)
+	if current == nil {
+		return nil
+	}
+
+	switch s.Follow {
+	case followRequester:
+		return current
+	case followBot:
+		if g.VoiceConnection != nil {
+			if channel, _ := discord.State.Channel(g.VoiceConnection.ChannelID); channel != nil {
+				return channel
+			}
+		}
+	case followHome:
+		if channel, _ := discord.State.Channel(s.HomeChannel); channel != nil {
+			return channel
+		}
+	}
+	return play.Channel
+}
+
+// Handles the !follow command
+func handleFollowCommand(m *discordgo.MessageCreate, parts []string, guild *discordgo.Guild) {
+	if len(parts) < 2 {
+		s := getGuild(guild).Settings()
+		policy := s.Follow
+		if policy == followOff {
+			policy = "off"
+		}
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Follow policy is `%s`. Usage: `!follow requester|bot|home|off`", policy))
+		return
+	}
+
+	if !isGuildAdmin(m.Author.ID, m.ChannelID) {
+		discord.ChannelMessageSend(m.ChannelID, "Only server admins can change the follow policy.")
+		return
+	}
+
+	policy := parts[1]
+	var home string
+	switch policy {
+	case "off":
+		policy = followOff
+	case followRequester, followBot:
+	case followHome:
+		// Home is the voice channel the admin is currently in
+		channel := getCurrentVoiceChannel(m.Author, guild)
+		if channel == nil {
+			discord.ChannelMessageSend(m.ChannelID, "Join the voice channel you want to set as home first.")
+			return
+		}
+		home = channel.ID
+	default:
+		discord.ChannelMessageSend(m.ChannelID, "Unknown follow policy. Usage: `!follow requester|bot|home|off`")
+		return
+	}
+
+	settingsMutex.Lock()
+	s := getSettings(guild.ID)
+	s.Follow = &policy
+	s.HomeChannel = &home
+	saveSettings()
+	settingsMutex.Unlock()
+
+	discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Follow policy is now `%s`.", parts[1]))
+}
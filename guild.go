@@ -1,39 +1,346 @@
 package main
 
-import "github.com/bwmarrin/discordgo"
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Disconnect reasons, logged when the bot leaves voice
+const (
+	reasonQueueEmpty = "queue empty"
+	reasonCommand    = "command"
+	reasonAlone      = "alone"
+	reasonShutdown   = "shutdown"
+	reasonVoiceError = "voice connection failed"
+)
 
 // Guild wraps Discord's guild and adds extra info that should carry over the app
 type Guild struct {
 	Guild             *discordgo.Guild
 	VoiceConnection   *discordgo.VoiceConnection
 	Queue             chan *Play
-	History           [MAX_HISTORY_SIZE]*Play
+	History           []*HistoryEntry
 	SkipPending       bool
+	SkipUser          *discordgo.User
 	DisconnectPending bool
+	DisconnectReason  string
 	State             int
+
+	// Play currently being played, nil between plays. Set with setPlaying, read with NowPlaying.
+	Playing *Play
+
+	// Guards Queue so it can be inspected while the player is running
+	queueMutex sync.Mutex
+
+	// Guards changes to VoiceConnection
+	voiceMutex sync.Mutex
+
+	// Guards History, which is shared by the player and commands
+	historyMutex sync.Mutex
+
+	// Last known voice channel of each user, used to detect joins
+	UserChannels map[string]string
+
+	// When each user's intro was last played
+	IntroPlayed map[string]time.Time
+
+	// Guards UserChannels and IntroPlayed, voice state updates are handled concurrently
+	userMutex sync.Mutex
+
+	// Fires when the bot has been alone in the voice channel for too long. The mutex guards it,
+	// as it's started and stopped by voice state updates, the player and the timer itself.
+	aloneTimer *time.Timer
+	aloneMutex sync.Mutex
+
+	// Clips mixed on top of the playing clip, and how far the playing clip has been turned down
+	// for them, from 0 (full volume) to 1 (ducked). The mutex guards Playing too, so overlays
+	// are only added while a clip is playing.
+	overlays     []*Overlay
+	ducking      float64
+	overlayMutex sync.Mutex
+
+	// Next play already faded in over the end of the playing clip, only used by the player
+	crossfade *Crossfade
+}
+
+// Get the extra data of the guild, creating it if necessary
+func getGuild(guild *discordgo.Guild) *Guild {
+	guildsMutex.Lock()
+	defer guildsMutex.Unlock()
+
+	if guilds[guild.ID] == nil {
+		g := &Guild{
+			Guild:             guild,
+			VoiceConnection:   nil,
+			Queue:             nil,
+			SkipPending:       false,
+			DisconnectPending: false,
+			State:             0,
+			UserChannels:      make(map[string]string),
+			IntroPlayed:       make(map[string]time.Time),
+		}
+
+		// Users already in voice shouldn't be seen as joining
+		discord.State.RLock()
+		for _, vs := range guild.VoiceStates {
+			g.UserChannels[vs.UserID] = vs.ChannelID
+		}
+		discord.State.RUnlock()
+
+		g.loadHistory()
+		guilds[guild.ID] = g
+	}
+	return guilds[guild.ID]
+}
+
+// Get the extra data of the guild by ID, nil if the guild hasn't been seen yet
+func lookupGuild(id string) *Guild {
+	guildsMutex.RLock()
+	defer guildsMutex.RUnlock()
+	return guilds[id]
+}
+
+// Returns the extra data of all guilds, the slice can be used while guilds are being added
+func allGuilds() []*Guild {
+	guildsMutex.RLock()
+	defer guildsMutex.RUnlock()
+
+	all := make([]*Guild, 0, len(guilds))
+	for _, g := range guilds {
+		all = append(all, g)
+	}
+	return all
 }
 
 // Disconnect guild's voice connection
+// Safe to call more than once, the player and shutdown may both disconnect.
 func (g *Guild) Disconnect() {
-	g.VoiceConnection.Disconnect()
+	g.voiceMutex.Lock()
+	vc := g.VoiceConnection
 	g.VoiceConnection = nil
+	g.voiceMutex.Unlock()
+
+	if vc != nil {
+		vc.Disconnect()
+	}
 	g.Reset()
 }
 
+// Sets the play being played
+func (g *Guild) setPlaying(play *Play) {
+	g.overlayMutex.Lock()
+	defer g.overlayMutex.Unlock()
+	g.Playing = play
+}
+
+// NowPlaying returns the play being played, nil between plays
+func (g *Guild) NowPlaying() *Play {
+	g.overlayMutex.Lock()
+	defer g.overlayMutex.Unlock()
+	return g.Playing
+}
+
 // Reset guild's queue and pending operations
 // Called when the bot disconnects
 func (g *Guild) Reset() {
+	g.queueMutex.Lock()
 	g.Queue = nil
+	g.queueMutex.Unlock()
+
+	g.setPlaying(nil)
 	g.DisconnectPending = false
+	g.DisconnectReason = ""
 	g.SkipPending = false
-	g.State = 0
+	g.SkipUser = nil
+	g.SetMode(0)
+	g.clearOverlays()
+
+	g.aloneMutex.Lock()
+	g.stopAloneTimer()
+	g.aloneMutex.Unlock()
+}
+
+// Alone reports whether there are no users besides bots in the bot's voice channel
+func (g *Guild) Alone() bool {
+	g.voiceMutex.Lock()
+	vc := g.VoiceConnection
+	g.voiceMutex.Unlock()
+	if vc == nil {
+		return false
+	}
+
+	// Members are looked up after releasing the state, which locks it again
+	users := []string{}
+	discord.State.RLock()
+	for _, vs := range g.Guild.VoiceStates {
+		if vs.ChannelID == vc.ChannelID && vs.UserID != discord.State.Ready.User.ID {
+			users = append(users, vs.UserID)
+		}
+	}
+	discord.State.RUnlock()
+
+	for _, userID := range users {
+		member, _ := discord.State.Member(g.Guild.ID, userID)
+		if member != nil && member.User != nil && member.User.Bot {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// CheckAlone starts the idle timer when the bot is left alone in voice, and stops it when someone joins.
+// When the timer fires, RNG4EVER is stopped, the queue is cleared and the bot disconnects.
+func (g *Guild) CheckAlone() {
+	g.aloneMutex.Lock()
+	defer g.aloneMutex.Unlock()
+
+	if !g.Alone() {
+		g.stopAloneTimer()
+		return
+	}
+
+	if g.aloneTimer != nil {
+		return
+	}
+
+	timeout := getConfig().AloneTimeout
+	log.WithFields(log.Fields{
+		"guild":   g.Guild.Name,
+		"timeout": timeout,
+	}).Debug("Alone in voice channel")
+
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		// The timer may have been stopped or replaced while it fired, and someone may have joined since
+		g.aloneMutex.Lock()
+		if g.aloneTimer != timer {
+			g.aloneMutex.Unlock()
+			return
+		}
+		g.aloneTimer = nil
+		alone := g.Alone()
+		g.aloneMutex.Unlock()
+
+		if alone {
+			g.Stop(reasonAlone, true)
+		}
+	})
+	g.aloneTimer = timer
+}
+
+// Stops the idle timer if it's running, the caller must hold aloneMutex
+func (g *Guild) stopAloneTimer() {
+	if g.aloneTimer != nil {
+		g.aloneTimer.Stop()
+		g.aloneTimer = nil
+	}
+}
+
+// Stop ends RNG4EVER mode and clears the queue, so the bot disconnects after the current clip.
+// If immediately is set, the current clip is interrupted as well.
+func (g *Guild) Stop(reason string, immediately bool) {
+	g.SetMode(0)
+	g.ClearQueue()
+	g.DisconnectReason = reason
+	g.DisconnectPending = immediately
+}
+
+// SetMode changes the playback mode, publishing an event if it changed
+func (g *Guild) SetMode(mode int) {
+	if g.State == mode {
+		return
+	}
+	g.State = mode
+	publish(Event{Type: eventModeChanged, Guild: g.Guild.ID, Mode: modeNames[mode]})
+}
+
+// Enqueue adds the play to the queue, dropping it if the queue is full.
+// Returns true if the queue was just created and the player should be started.
+func (g *Guild) Enqueue(play *Play) bool {
+	g.queueMutex.Lock()
+	defer g.queueMutex.Unlock()
+
+	if g.Queue == nil {
+		g.Queue = make(chan *Play, getConfig().MaxQueueSize)
+		g.Queue <- play
+		publishPlay(eventEnqueued, play)
+		return true
+	}
+
+	if len(g.Queue) < cap(g.Queue) {
+		g.Queue <- play
+		publishPlay(eventEnqueued, play)
+	}
+	return false
 }
 
-// SaveToHistory adds the play to the guild's play history
-// Automatically removes oldest play when full
-func (g *Guild) SaveToHistory(p *Play) {
-	for i := len(g.History) - 1; i > 0; i-- {
-		g.History[i] = g.History[i-1]
+// Next takes the next play from the queue, or returns nil if the queue is empty
+func (g *Guild) Next() *Play {
+	g.queueMutex.Lock()
+	defer g.queueMutex.Unlock()
+
+	if g.Queue == nil || len(g.Queue) <= 0 {
+		return nil
+	}
+	return <-g.Queue
+}
+
+// TakeNext takes the next play from the queue if accept accepts it, or returns nil
+func (g *Guild) TakeNext(accept func(play *Play) bool) *Play {
+	g.queueMutex.Lock()
+	defer g.queueMutex.Unlock()
+
+	if g.Queue == nil || len(g.Queue) <= 0 {
+		return nil
+	}
+
+	// The channel is drained and refilled without the taken play, which keeps the order
+	plays := []*Play{}
+	for len(g.Queue) > 0 {
+		plays = append(plays, <-g.Queue)
+	}
+	next := plays[0]
+	if accept(next) {
+		plays = plays[1:]
+	} else {
+		next = nil
+	}
+	for _, play := range plays {
+		g.Queue <- play
+	}
+	return next
+}
+
+// Queued returns the plays waiting in the queue, in order
+func (g *Guild) Queued() []*Play {
+	g.queueMutex.Lock()
+	defer g.queueMutex.Unlock()
+
+	plays := []*Play{}
+	if g.Queue == nil {
+		return plays
+	}
+
+	// The channel is drained and refilled, which keeps the order
+	for len(g.Queue) > 0 {
+		plays = append(plays, <-g.Queue)
+	}
+	for _, play := range plays {
+		g.Queue <- play
+	}
+	return plays
+}
+
+// ClearQueue removes all plays from the queue
+func (g *Guild) ClearQueue() {
+	g.queueMutex.Lock()
+	defer g.queueMutex.Unlock()
+
+	for g.Queue != nil && len(g.Queue) > 0 {
+		<-g.Queue
 	}
-	g.History[0] = p
 }
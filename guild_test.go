@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestTakeNext(t *testing.T) {
+	names := func(plays []*Play) []string {
+		result := []string{}
+		for _, play := range plays {
+			result = append(result, play.Sound.Name)
+		}
+		return result
+	}
+
+	tests := []struct {
+		queue  []string
+		accept bool
+		taken  string
+		left   []string
+	}{
+		{[]string{}, true, "", []string{}},
+		{[]string{"a"}, true, "a", []string{}},
+		{[]string{"a", "b", "c"}, true, "a", []string{"b", "c"}},
+		{[]string{"a", "b", "c"}, false, "", []string{"a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		g := &Guild{Queue: make(chan *Play, 4)}
+		for _, name := range test.queue {
+			g.Queue <- &Play{Sound: &Sound{Name: name}}
+		}
+
+		play := g.TakeNext(func(play *Play) bool { return test.accept })
+		taken := ""
+		if play != nil {
+			taken = play.Sound.Name
+		}
+
+		left := names(g.Queued())
+		if taken != test.taken || len(left) != len(test.left) {
+			t.Errorf("TakeNext(%q, %v) = %q leaving %q, want %q leaving %q", test.queue, test.accept, taken, left, test.taken, test.left)
+			continue
+		}
+		for i := range left {
+			if left[i] != test.left[i] {
+				t.Errorf("TakeNext(%q, %v) left %q, want %q", test.queue, test.accept, left, test.left)
+				break
+			}
+		}
+	}
+
+	// A guild without a queue has nothing to take
+	if play := (&Guild{}).TakeNext(func(*Play) bool { return true }); play != nil {
+		t.Errorf("TakeNext() without a queue = %v, want nil", play)
+	}
+}
@@ -1,17 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"strings"
-	"text/tabwriter"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/bwmarrin/discordgo"
 )
 
 func onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if len(m.Content) <= 0 || (m.Content[0] != '!' && len(m.Mentions) < 1) {
+	if isShuttingDown() || len(m.Content) <= 0 || (m.Content[0] != '!' && len(m.Mentions) < 1) {
 		return
 	}
 
@@ -38,7 +36,7 @@ func onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 	}
 
 	// If this is a mention, it should come from the owner (otherwise we don't care)
-	if len(m.Mentions) > 0 && m.Author.ID == OWNER && len(parts) > 0 {
+	if len(m.Mentions) > 0 && m.Author.ID == getConfig().Owner && len(parts) > 0 {
 		mentioned := false
 		for _, mention := range m.Mentions {
 			mentioned = (mention.ID == s.State.Ready.User.ID)
@@ -49,86 +47,132 @@ func onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 
 		if mentioned {
 			handleBotControlMessages(s, m, parts, guild)
+			return
 		}
-		return
 	}
 
-	if guilds[m.GuildID] == nil {
-		guilds[m.GuildID] = &Guild{
-			Guild:             guild,
-			VoiceConnection:   nil,
-			Queue:             nil,
-			SkipPending:       false,
-			DisconnectPending: false,
-			State:             0,
-		}
-	}
-	guildData := guilds[m.GuildID]
+	guildData := getGuild(guild)
 
+	// Commands are counted in metrics by the branch handling them
 	if parts[0] == "!dd" {
+		countCommand("dd")
 		if guildData.VoiceConnection != nil {
+			guildData.DisconnectReason = reasonCommand
+			guildData.SkipUser = m.Author
 			guildData.DisconnectPending = true
-			return
 		}
+		return
 	} else if parts[0] == "!skip" {
+		countCommand("skip")
+		guildData.SkipUser = m.Author
 		guildData.SkipPending = true
+		return
 	} else if parts[0] == "!history" {
-		if guildData.History[0] == nil {
-			discord.ChannelMessageSend(channel.ID, "This guild has no history since last bot restart.")
-			return
-		}
-
-		w := &tabwriter.Writer{}
-		buf := &bytes.Buffer{}
-
-		w.Init(buf, 0, 4, 0, ' ', 0)
-		fmt.Fprintf(w, ">>> Recently played sounds for current guild:\n")
-
-		for i, el := range guildData.History {
-			if el != nil {
-				styling := ""
-				if el.Skipped {
-					styling += "~~"
-				}
-				if el.Forced {
-					styling += "**"
-				}
-
-				fmt.Fprintf(w, "%s%d. %s !%s%s\n", styling, i+1, el.Sound.Name, el.Sound.Collection.Prefix, Reverse(styling))
-			}
+		countCommand("history")
+		handleHistoryCommand(s, m, parts, guildData)
+		return
+	} else if parts[0] == "!list" {
+		countCommand("list")
+		if len(parts) > 1 {
+			listSounds(channel.ID, strings.Join(parts[1:], " "))
+		} else {
+			listCollections(channel.ID)
 		}
-
-		w.Flush()
-		discord.ChannelMessageSend(channel.ID, buf.String())
+		return
+	} else if parts[0] == "!search" {
+		countCommand("search")
+		searchSounds(channel.ID, strings.Join(parts[1:], " "))
+		return
+	} else if parts[0] == "!intro" {
+		countCommand("intro")
+		handleIntroCommand(m, parts, guild)
+		return
+	} else if parts[0] == "!follow" {
+		countCommand("follow")
+		handleFollowCommand(m, parts, guild)
+		return
+	} else if parts[0] == "!top" {
+		countCommand("top")
+		handleTopCommand(m, parts, guild)
+		return
+	} else if parts[0] == "!upload" {
+		countCommand("upload")
+		handleUploadCommand(m, parts)
+		return
+	} else if parts[0] == "!clip" {
+		countCommand("clip")
+		handleClipCommand(m, parts)
+		return
+	} else if parts[0] == "!trim" {
+		countCommand("trim")
+		handleTrimCommand(m, parts)
+		return
+	} else if parts[0] == "!overlay" {
+		countCommand("overlay")
+		handleOverlayCommand(m, parts, guild)
+		return
+	} else if parts[0] == "!crossfade" {
+		countCommand("crossfade")
+		handleCrossfadeCommand(m, parts, guild)
+		return
+	} else if parts[0] == "!volume" {
+		countCommand("volume")
+		handleVolumeCommand(m, parts, guild)
+		return
 	}
 
 	// Find the collection for the command we got
-	for _, coll := range COLLECTIONS {
+	for _, coll := range allCollections() {
 		if scontains(parts[0], coll.Commands...) {
+			countCommand("play")
 
 			if len(parts) >= 2 && parts[1] == "rng4ever" {
-				guildData.State = RNG4EVER
+				guildData.SetMode(RNG4EVER)
 				parts = parts[0:1]
 			}
 
-			// If they passed a specific sound effect, find and select that (otherwise play nothing)
+			effects, args, err := parseEffects(parts[1:])
+			if err != nil {
+				discord.ChannelMessageSend(channel.ID, err.Error())
+				return
+			}
+			parts = append(parts[0:1], args...)
+
+			// If they passed a specific sound effect, find and select that (otherwise suggest close matches)
 			var sound *Sound
 			if len(parts) > 1 {
-				for _, s := range coll.Sounds {
-					if strings.Join(parts[1:len(parts)], " ") == s.Name {
-						sound = s
-					}
-				}
+				query := strings.Join(parts[1:len(parts)], " ")
 
+				var suggestions []*Sound
+				sound, suggestions = coll.Find(query)
 				if sound == nil {
+					sendSuggestions(channel.ID, coll, query, suggestions)
+					return
+				}
+				if !effects.None() && !effects.Fits(len(sound.Frames())) {
+					discord.ChannelMessageSend(channel.ID, fmt.Sprintf("Effects can only be used on clips up to %s.", MAX_EFFECTS_LENGTH))
 					return
 				}
 			}
 
-			go enqueuePlay(m.Author, guild, coll, sound)
+			go enqueuePlayWith(m.Author, guild, coll, sound, effects)
 			return
 		}
 	}
+
+	if strings.HasPrefix(parts[0], "!") {
+		countCommand("unknown")
+	}
+}
+
+// Checks whether the user is allowed to change guild settings
+func isGuildAdmin(userID string, channelID string) bool {
+	if userID == getConfig().Owner {
+		return true
+	}
+
+	perms, err := discord.State.UserChannelPermissions(userID, channelID)
+	return err == nil && perms&discordgo.PermissionManageServer != 0
 }
 
 // Reverse the string
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Gateway is considered wedged if no heartbeat has been acknowledged for this long
+const MAX_HEARTBEAT_AGE = 2 * time.Minute
+
+var (
+	// Set when READY has been received and cleared when the gateway disconnects
+	gatewayReady int32
+
+	// Set when all sounds have been preloaded
+	audioLoaded int32
+)
+
+func setGatewayReady(ready bool) {
+	var value int32
+	if ready {
+		value = 1
+	}
+	atomic.StoreInt32(&gatewayReady, value)
+}
+
+func onResumed(s *discordgo.Session, event *discordgo.Resumed) {
+	setGatewayReady(true)
+}
+
+func onDisconnect(s *discordgo.Session, event *discordgo.Disconnect) {
+	setGatewayReady(false)
+}
+
+// Health is the response of the health endpoints
+type Health struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks,omitempty"`
+	Stats  BotStats          `json:"stats"`
+}
+
+// Runs the readiness checks, returning "ok" or the reason of failure for each
+func readinessChecks() (map[string]string, bool) {
+	checks := map[string]string{
+		"gateway": "ok",
+		"stats":   "ok",
+		"audio":   "ok",
+	}
+
+	discord.RLock()
+	ack := discord.LastHeartbeatAck
+	discord.RUnlock()
+
+	if atomic.LoadInt32(&gatewayReady) != 1 {
+		checks["gateway"] = "READY not received"
+	} else if !ack.IsZero() && time.Since(ack) > MAX_HEARTBEAT_AGE {
+		checks["gateway"] = "no heartbeat acknowledged since " + ack.Format(time.RFC3339)
+	}
+
+	if rcli == nil {
+		checks["stats"] = "disabled"
+	} else if err := rcli.Ping().Err(); err != nil {
+		checks["stats"] = err.Error()
+	}
+
+	if atomic.LoadInt32(&audioLoaded) != 1 {
+		checks["audio"] = "not loaded"
+	}
+
+	ready := true
+	for _, result := range checks {
+		if result != "ok" && result != "disabled" {
+			ready = false
+		}
+	}
+	return checks, ready
+}
+
+func writeHealth(w http.ResponseWriter, status int, health Health) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
+
+// Reports that the process is alive
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, Health{
+		Ready: atomic.LoadInt32(&gatewayReady) == 1,
+		Stats: collectBotStats(),
+	})
+}
+
+// Reports whether the bot is connected and able to play, with 503 status if it's not
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks, ready := readinessChecks()
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeHealth(w, status, Health{
+		Ready:  ready,
+		Checks: checks,
+		Stats:  collectBotStats(),
+	})
+}
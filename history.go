@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+	"github.com/dustin/go-humanize"
+)
+
+// Number of history entries shown per page
+const HISTORY_PAGE_SIZE = 20
+
+// HistoryEntry is a single play in the guild's history
+type HistoryEntry struct {
+	PlayRef
+	Time      time.Time `json:"time"`
+	Username  string    `json:"username"`
+	SkippedBy string    `json:"skipped_by,omitempty"`
+}
+
+// Name of the guild's history file in the data directory
+func historyFile(guildID string) string {
+	return fmt.Sprintf("history-%s.json", guildID)
+}
+
+// Load the guild's history from disk
+func (g *Guild) loadHistory() {
+	g.historyMutex.Lock()
+	defer g.historyMutex.Unlock()
+
+	if err := loadData(historyFile(g.Guild.ID), &g.History); err != nil {
+		log.WithFields(log.Fields{
+			"guild": g.Guild.Name,
+			"error": err,
+		}).Warning("Failed to load history")
+	}
+}
+
+// Save the guild's history to disk
+func (g *Guild) saveHistory() {
+	g.historyMutex.Lock()
+	defer g.historyMutex.Unlock()
+
+	if err := saveData(historyFile(g.Guild.ID), g.History); err != nil {
+		log.WithFields(log.Fields{
+			"guild": g.Guild.Name,
+			"error": err,
+		}).Warning("Failed to save history")
+	}
+}
+
+// SaveToHistory adds the play to the guild's play history
+// Automatically removes oldest play when full
+func (g *Guild) SaveToHistory(p *Play) *HistoryEntry {
+	g.historyMutex.Lock()
+	defer g.historyMutex.Unlock()
+
+	entry := &HistoryEntry{
+		PlayRef:  refPlay(p),
+		Time:     time.Now(),
+		Username: p.User.Username,
+	}
+
+	g.History = append([]*HistoryEntry{entry}, g.History...)
+	if size := getConfig().MaxHistorySize; len(g.History) > size {
+		g.History = g.History[0:size]
+	}
+	return entry
+}
+
+// Marks the history entry skipped, by the user if one skipped it
+func (g *Guild) markSkipped(entry *HistoryEntry, user *discordgo.User) {
+	g.historyMutex.Lock()
+	defer g.historyMutex.Unlock()
+
+	entry.Skipped = true
+	if user != nil {
+		entry.SkippedBy = user.ID
+	}
+}
+
+// FilterHistory returns at most n entries (all if n is zero) played by the user in the collection.
+// Empty user or collection matches all entries.
+func (g *Guild) FilterHistory(n int, userID string, collection string) []*HistoryEntry {
+	g.historyMutex.Lock()
+	defer g.historyMutex.Unlock()
+
+	entries := []*HistoryEntry{}
+	for _, entry := range g.History {
+		if n > 0 && len(entries) >= n {
+			break
+		}
+		if userID != "" && entry.User != userID {
+			continue
+		}
+		if collection != "" && entry.Collection != collection {
+			continue
+		}
+
+		// Entries are copied, the player may mark them skipped meanwhile
+		copied := *entry
+		entries = append(entries, &copied)
+	}
+	return entries
+}
+
+// Handles the !history [n] [@user] [collection] command
+func handleHistoryCommand(s *discordgo.Session, m *discordgo.MessageCreate, parts []string, g *Guild) {
+	var (
+		n          int
+		user       *discordgo.User
+		collection string
+	)
+
+	// Mentions are read from the raw message, usernames with spaces would be split into several parts
+	user = utilGetMentioned(s, m)
+	for _, part := range strings.Fields(m.Content)[1:] {
+		if strings.HasPrefix(part, "<@") && strings.HasSuffix(part, ">") {
+			continue
+		}
+
+		if i, err := strconv.Atoi(part); err == nil && i > 0 {
+			n = i
+		} else if coll := findCollection(part); coll != nil {
+			collection = coll.Prefix
+		} else {
+			discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No collection called \"%s\". Usage: `!history [N] [@USER] [COLLECTION]`", part))
+			return
+		}
+	}
+
+	userID := ""
+	header := ">>> Recently played sounds for current guild"
+	if user != nil {
+		userID = user.ID
+		header += fmt.Sprintf(" by %s", user.Username)
+	}
+	if collection != "" {
+		header += fmt.Sprintf(" from !%s", collection)
+	}
+
+	entries := g.FilterHistory(n, userID, collection)
+	if len(entries) <= 0 {
+		discord.ChannelMessageSend(m.ChannelID, "No plays found in this guild's history.")
+		return
+	}
+
+	lines := []string{}
+	for i, el := range entries {
+		styling := ""
+		if el.Skipped {
+			styling += "~~"
+		}
+		if el.Forced {
+			styling += "**"
+		}
+
+		effects := ""
+		if el.Effects != "" {
+			effects = " " + el.Effects
+		}
+
+		lines = append(lines, fmt.Sprintf("%s%d. %s !%s%s%s - %s, %s", styling, i+1, el.Sound, el.Collection, effects, Reverse(styling), el.Username, humanize.Time(el.Time)))
+	}
+
+	sendPaginated(m.ChannelID, header+":", lines, HISTORY_PAGE_SIZE)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Routes of the bot's HTTP server
+var httpMux = http.NewServeMux()
+
+// Serves the HTTP endpoints on the configured address, does nothing if it's not set
+func startHTTP() {
+	addr := getConfig().HTTP.Listen
+	if addr == "" {
+		return
+	}
+
+	httpMux.Handle("/metrics", promhttp.Handler())
+	httpMux.HandleFunc("/healthz", handleHealthz)
+	httpMux.HandleFunc("/readyz", handleReadyz)
+	httpMux.HandleFunc("/api/", handleAPI)
+	httpMux.HandleFunc("/", handleSoundboard)
+
+	log.WithFields(log.Fields{
+		"addr": addr,
+	}).Info("Starting HTTP server")
+
+	go func() {
+		if err := http.ListenAndServe(addr, httpMux); err != nil {
+			log.WithFields(log.Fields{
+				"addr":  addr,
+				"error": err,
+			}).Error("HTTP server failed")
+		}
+	}()
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+)
+
+const introsFile = "intros.json"
+
+// IntroClip references the clip played when a user joins voice
+type IntroClip struct {
+	Collection string `json:"collection"`
+	Sound      string `json:"sound"`
+}
+
+// IntroSettings holds the entrance sounds of a guild
+type IntroSettings struct {
+	Disabled bool                  `json:"disabled"`
+	Default  *IntroClip            `json:"default,omitempty"`
+	Users    map[string]*IntroClip `json:"users"`
+}
+
+var (
+	// Intro settings by guild ID
+	intros      = make(map[string]*IntroSettings)
+	introsMutex sync.Mutex
+)
+
+// Load intro settings from disk
+func loadIntros() {
+	introsMutex.Lock()
+	defer introsMutex.Unlock()
+
+	if err := loadData(introsFile, &intros); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to load intros")
+	}
+}
+
+// Save intro settings to disk, caller must hold introsMutex
+func saveIntros() {
+	if err := saveData(introsFile, intros); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to save intros")
+	}
+}
+
+// Get the intro settings of the guild, caller must hold introsMutex
+func getIntroSettings(guildID string) *IntroSettings {
+	if intros[guildID] == nil {
+		intros[guildID] = &IntroSettings{
+			Users: make(map[string]*IntroClip),
+		}
+	}
+	return intros[guildID]
+}
+
+// Resolve finds the collection and sound the intro refers to by its exact name, nil if it no longer exists
+func (c *IntroClip) Resolve() (*SoundCollection, *Sound) {
+	coll := findCollection(c.Collection)
+	if coll == nil {
+		return nil, nil
+	}
+	return coll, coll.Sound(c.Sound)
+}
+
+// Finds the clip given in the command, replying with suggestions if it was not found
+func parseIntroClip(cid string, parts []string) *IntroClip {
+	if len(parts) < 2 {
+		discord.ChannelMessageSend(cid, "Usage: `!intro set <COLLECTION> <CLIP>`")
+		return nil
+	}
+
+	coll := findCollection(parts[0])
+	if coll == nil {
+		discord.ChannelMessageSend(cid, fmt.Sprintf("No collection called \"%s\".", parts[0]))
+		return nil
+	}
+
+	query := strings.Join(parts[1:], " ")
+	sound, suggestions := coll.Find(query)
+	if sound == nil {
+		sendSuggestions(cid, coll, query, suggestions)
+		return nil
+	}
+
+	return &IntroClip{
+		Collection: coll.Prefix,
+		Sound:      sound.Name,
+	}
+}
+
+// Handles the !intro command
+func handleIntroCommand(m *discordgo.MessageCreate, parts []string, guild *discordgo.Guild) {
+	if len(parts) < 2 {
+		discord.ChannelMessageSend(m.ChannelID, "Usage: `!intro set <COLLECTION> <CLIP>`, `!intro clear`, `!intro default <COLLECTION> <CLIP>|clear`, `!intro on|off`")
+		return
+	}
+
+	// Guild-wide settings are restricted to admins
+	if scontains(parts[1], "default", "on", "off") && !isGuildAdmin(m.Author.ID, m.ChannelID) {
+		discord.ChannelMessageSend(m.ChannelID, "Only server admins can change guild intro settings.")
+		return
+	}
+
+	var clip *IntroClip
+	if scontains(parts[1], "set") || (parts[1] == "default" && !(len(parts) == 3 && parts[2] == "clear")) {
+		if clip = parseIntroClip(m.ChannelID, parts[2:]); clip == nil {
+			return
+		}
+	}
+
+	introsMutex.Lock()
+	defer introsMutex.Unlock()
+	settings := getIntroSettings(guild.ID)
+
+	switch parts[1] {
+	case "set":
+		settings.Users[m.Author.ID] = clip
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Your intro is now `!%s %s`.", clip.Collection, clip.Sound))
+	case "clear":
+		delete(settings.Users, m.Author.ID)
+		discord.ChannelMessageSend(m.ChannelID, "Your intro was cleared.")
+	case "default":
+		settings.Default = clip
+		if clip == nil {
+			discord.ChannelMessageSend(m.ChannelID, "Default intro was cleared.")
+		} else {
+			discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Default intro is now `!%s %s`.", clip.Collection, clip.Sound))
+		}
+	case "on", "off":
+		settings.Disabled = parts[1] == "off"
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Intros are now %s.", parts[1]))
+	default:
+		discord.ChannelMessageSend(m.ChannelID, "Unknown intro command.")
+		return
+	}
+
+	saveIntros()
+}
+
+// Queues the user's intro, or the guild's default intro, to the channel they joined
+func playIntro(g *Guild, userID string, channelID string) {
+	introsMutex.Lock()
+	settings := intros[g.Guild.ID]
+	if settings == nil || settings.Disabled {
+		introsMutex.Unlock()
+		return
+	}
+
+	clip := settings.Users[userID]
+	if clip == nil {
+		clip = settings.Default
+	}
+	introsMutex.Unlock()
+
+	if clip == nil {
+		return
+	}
+
+	// Don't drag the bot away from another channel
+	if g.VoiceConnection != nil && g.VoiceConnection.ChannelID != channelID {
+		return
+	}
+
+	member, _ := discord.State.Member(g.Guild.ID, userID)
+	if member == nil || member.User == nil || member.User.Bot {
+		return
+	}
+
+	coll, sound := clip.Resolve()
+	if sound == nil {
+		log.WithFields(log.Fields{
+			"guild":      g.Guild.Name,
+			"user":       userID,
+			"collection": clip.Collection,
+			"sound":      clip.Sound,
+		}).Warning("Intro clip no longer exists")
+		return
+	}
+
+	// Cooldown keeps reconnecting users from spamming
+	if !g.claimIntro(userID) {
+		return
+	}
+	go enqueuePlay(member.User, g.Guild, coll, sound)
+}
+
+// Records that the user's intro is played, returns false if it was played within the cooldown
+func (g *Guild) claimIntro(userID string) bool {
+	g.userMutex.Lock()
+	defer g.userMutex.Unlock()
+
+	if time.Since(g.IntroPlayed[userID]) < getConfig().IntroCooldown {
+		return false
+	}
+	g.IntroPlayed[userID] = time.Now()
+	return true
+}
+
+// Updates the intros referring to a clip that has been renamed or moved
+func renameIntroClips(oldCollection string, oldSound string, newCollection string, newSound string) {
+	introsMutex.Lock()
+	defer introsMutex.Unlock()
+
+	changed := false
+	for _, settings := range intros {
+		clips := []*IntroClip{settings.Default}
+		for _, clip := range settings.Users {
+			clips = append(clips, clip)
+		}
+
+		for _, clip := range clips {
+			if clip != nil && clip.Collection == oldCollection && clip.Sound == oldSound {
+				clip.Collection = newCollection
+				clip.Sound = newSound
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		saveIntros()
+	}
+}
+
+// Clears the intros referring to a clip that has been deleted
+func clearIntroClips(collection string, sound string) {
+	introsMutex.Lock()
+	defer introsMutex.Unlock()
+
+	matches := func(clip *IntroClip) bool {
+		return clip != nil && clip.Collection == collection && clip.Sound == sound
+	}
+
+	changed := false
+	for _, settings := range intros {
+		if matches(settings.Default) {
+			settings.Default = nil
+			changed = true
+		}
+		for userID, clip := range settings.Users {
+			if matches(clip) {
+				delete(settings.Users, userID)
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		log.WithFields(log.Fields{
+			"collection": collection,
+			"sound":      sound,
+		}).Info("Cleared intros of deleted sound")
+		saveIntros()
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestIntroResolve(t *testing.T) {
+	defer func(old []*SoundCollection) { COLLECTIONS = old }(COLLECTIONS)
+	COLLECTIONS = []*SoundCollection{{
+		Prefix: "memes",
+		Sounds: []*Sound{{Name: "airhorn"}, {Name: "airhorn_long"}},
+	}}
+
+	tests := []struct {
+		clip IntroClip
+		want string
+	}{
+		{IntroClip{"memes", "airhorn"}, "airhorn"},
+		{IntroClip{"memes", "airhorn_long"}, "airhorn_long"},
+		// Renamed or deleted clips don't fall back to similar names
+		{IntroClip{"memes", "airhorn_short"}, ""},
+		{IntroClip{"memes", "airhor"}, ""},
+		{IntroClip{"other", "airhorn"}, ""},
+	}
+
+	for _, test := range tests {
+		_, sound := test.clip.Resolve()
+		got := ""
+		if sound != nil {
+			got = sound.Name
+		}
+		if got != test.want {
+			t.Errorf("Resolve(%+v) = %q, want %q", test.clip, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+	redis "gopkg.in/redis.v3"
+)
+
+// Leaderboard settings
+const (
+	LEADERBOARD_SIZE = 10
+
+	// Daily buckets are kept a bit over a week, which is the longest window
+	DAILY_BUCKET_TTL = 8 * 24 * time.Hour
+
+	// Clips need this many plays before their skip rate is ranked
+	MIN_SKIPRATE_PLAYS = 5
+)
+
+// Leaderboard kinds
+const (
+	topClips       = "clips"
+	topUsers       = "users"
+	topSkipped     = "skipped"
+	topCollections = "collections"
+	topSkipRate    = "skiprate"
+)
+
+// Time windows
+const (
+	windowToday = "today"
+	windowWeek  = "week"
+	windowAll   = "all"
+)
+
+// Daily bucket name of the given time
+func dayBucket(t time.Time) string {
+	return "day:" + t.Format("20060102")
+}
+
+// Leaderboard key of the scope (empty guild ID for global) and bucket
+func leaderboardKey(guildID string, kind string, bucket string) string {
+	scope := "global"
+	if guildID != "" {
+		scope = "guild:" + guildID
+	}
+	return fmt.Sprintf("niksibot:top:%s:%s:%s", scope, kind, bucket)
+}
+
+// Member name of the sound in clip leaderboards and counters
+func clipMember(sound *Sound) string {
+	if sound.ID != "" {
+		return sound.ID
+	}
+	return sound.Collection.Prefix + "/" + sound.Name
+}
+
+// Finds the sound by its member name, which stays the same when the sound is renamed.
+// The caller must hold soundsMutex.
+func findClipMember(member string) *Sound {
+	for _, coll := range COLLECTIONS {
+		for _, sound := range coll.Sounds {
+			if clipMember(sound) == member {
+				return sound
+			}
+		}
+	}
+	return nil
+}
+
+// Adds increments of the member to the guild's and global leaderboards, all-time and today
+func incrLeaderboards(pipe *redis.Pipeline, guildID string, kind string, member string) {
+	today := dayBucket(time.Now())
+	for _, scope := range []string{"", guildID} {
+		pipe.ZIncrBy(leaderboardKey(scope, kind, "all"), 1, member)
+		pipe.ZIncrBy(leaderboardKey(scope, kind, today), 1, member)
+		pipe.Expire(leaderboardKey(scope, kind, today), DAILY_BUCKET_TTL)
+	}
+}
+
+// Reads the top entries of the leaderboard over the time window
+func readLeaderboard(guildID string, kind string, window string) ([]redis.Z, error) {
+	switch window {
+	case windowAll:
+		return rcli.ZRevRangeWithScores(leaderboardKey(guildID, kind, "all"), 0, LEADERBOARD_SIZE-1).Result()
+	case windowToday:
+		return rcli.ZRevRangeWithScores(leaderboardKey(guildID, kind, dayBucket(time.Now())), 0, LEADERBOARD_SIZE-1).Result()
+	}
+
+	// Week is combined from the daily buckets into a short-lived key
+	keys := []string{}
+	now := time.Now()
+	for i := 0; i < 7; i++ {
+		keys = append(keys, leaderboardKey(guildID, kind, dayBucket(now.AddDate(0, 0, -i))))
+	}
+
+	tmp := leaderboardKey(guildID, kind, fmt.Sprintf("tmp:%d", now.UnixNano()))
+	var result *redis.ZSliceCmd
+	_, err := rcli.Pipelined(func(pipe *redis.Pipeline) error {
+		pipe.ZUnionStore(tmp, redis.ZStore{}, keys...)
+		result = pipe.ZRevRangeWithScores(tmp, 0, LEADERBOARD_SIZE-1)
+		pipe.Del(tmp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Result()
+}
+
+// ClipSkipRate is the share of a clip's plays that were skipped
+type ClipSkipRate struct {
+	Member string
+	Plays  int
+	Skips  int
+}
+
+// Rate of skips per plays
+func (r ClipSkipRate) Rate() float64 {
+	return float64(r.Skips) / float64(r.Plays)
+}
+
+// Reads all-time skip rates of clips with enough plays, highest rate first
+func readSkipRates(guildID string) ([]ClipSkipRate, error) {
+	plays, err := rcli.HGetAllMap(clipCountersKey(guildID, "plays")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	skips, err := rcli.HGetAllMap(clipCountersKey(guildID, "skips")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	rates := []ClipSkipRate{}
+	for member, value := range plays {
+		p, _ := strconv.Atoi(value)
+		s, _ := strconv.Atoi(skips[member])
+		if p < MIN_SKIPRATE_PLAYS {
+			continue
+		}
+		rates = append(rates, ClipSkipRate{Member: member, Plays: p, Skips: s})
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].Rate() != rates[j].Rate() {
+			return rates[i].Rate() > rates[j].Rate()
+		}
+		return rates[i].Plays > rates[j].Plays
+	})
+	return rates, nil
+}
+
+// Formats the leaderboard member for display
+func formatMember(guild *discordgo.Guild, kind string, member string) string {
+	switch kind {
+	case topUsers:
+		if m, _ := discord.State.Member(guild.ID, member); m != nil && m.User != nil {
+			return m.User.Username
+		}
+		return member
+	case topCollections:
+		return "!" + member
+	}
+
+	soundsMutex.RLock()
+	defer soundsMutex.RUnlock()
+	if sound := findClipMember(member); sound != nil {
+		return fmt.Sprintf("!%s %s", sound.Collection.Prefix, sound.Name)
+	}
+	return "!" + strings.Replace(member, "/", " ", 1)
+}
+
+// Handles the !top <clips|users|skipped|collections> [global] [today|week|all] command
+func handleTopCommand(m *discordgo.MessageCreate, parts []string, guild *discordgo.Guild) {
+	usage := "Usage: `!top clips|users|skipped|collections [global] [today|week|all]` or `!top skiprate [global]`"
+	if len(parts) < 2 || !scontains(parts[1], topClips, topUsers, topSkipped, topCollections, topSkipRate) {
+		discord.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+
+	if rcli == nil {
+		discord.ChannelMessageSend(m.ChannelID, "Stats are not enabled.")
+		return
+	}
+
+	kind := parts[1]
+	guildID := guild.ID
+	window := windowAll
+	for _, part := range parts[2:] {
+		switch part {
+		case "global":
+			guildID = ""
+		case windowToday, windowWeek, windowAll:
+			window = part
+		default:
+			discord.ChannelMessageSend(m.ChannelID, usage)
+			return
+		}
+	}
+
+	scope := "this guild"
+	if guildID == "" {
+		scope = "all guilds"
+	}
+
+	if kind == topSkipRate {
+		displaySkipRates(m.ChannelID, guildID, scope, window)
+		return
+	}
+
+	entries, err := readLeaderboard(guildID, kind, window)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"kind":   kind,
+			"window": window,
+			"error":  err,
+		}).Warning("Failed to read leaderboard")
+		discord.ChannelMessageSend(m.ChannelID, "Failed to read stats.")
+		return
+	}
+
+	if len(entries) <= 0 {
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No %s stats for %s yet.", kind, scope))
+		return
+	}
+
+	unit := "plays"
+	if kind == topSkipped {
+		unit = "skips"
+	}
+
+	lines := []string{fmt.Sprintf(">>> **Top %s in %s (%s):**", kind, scope, window)}
+	for i, z := range entries {
+		lines = append(lines, fmt.Sprintf("%d. %s - %d %s", i+1, formatMember(guild, kind, fmt.Sprint(z.Member)), int(z.Score), unit))
+	}
+	discord.ChannelMessageSend(m.ChannelID, strings.Join(lines, "\n"))
+}
+
+// Displays clips with the highest skip rates, only all-time rates are kept
+func displaySkipRates(cid string, guildID string, scope string, window string) {
+	if window != windowAll {
+		discord.ChannelMessageSend(cid, "Skip rates are only available for all time.")
+		return
+	}
+
+	rates, err := readSkipRates(guildID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to read skip rates")
+		discord.ChannelMessageSend(cid, "Failed to read stats.")
+		return
+	}
+
+	if len(rates) <= 0 {
+		discord.ChannelMessageSend(cid, fmt.Sprintf("No clip in %s has been played %d times yet.", scope, MIN_SKIPRATE_PLAYS))
+		return
+	}
+
+	lines := []string{fmt.Sprintf(">>> **Most skipped clips in %s by skip rate:**", scope)}
+	for i, r := range rates {
+		if i >= LEADERBOARD_SIZE {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s - %.0f%% skipped (%d/%d)", i+1, formatMember(nil, topClips, r.Member), r.Rate()*100, r.Skips, r.Plays))
+	}
+	discord.ChannelMessageSend(cid, strings.Join(lines, "\n"))
+}
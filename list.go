@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Formats the duration as minutes and seconds
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// Finds the collection by its name, with or without the command prefix
+func findCollection(name string) *SoundCollection {
+	name = strings.TrimPrefix(strings.ToLower(name), "!")
+	for _, coll := range allCollections() {
+		if strings.ToLower(coll.Prefix) == name {
+			return coll
+		}
+	}
+	return nil
+}
+
+// Lists all collections with their clip counts and total durations
+func listCollections(cid string) {
+	soundsMutex.RLock()
+	lines := []string{}
+	for _, coll := range COLLECTIONS {
+		lines = append(lines, fmt.Sprintf("!%s - %d clips, %s", coll.Prefix, len(coll.Sounds), formatDuration(coll.Duration())))
+	}
+	title := fmt.Sprintf(">>> **%d collections, %d clips:**", len(COLLECTIONS), SoundCount)
+	soundsMutex.RUnlock()
+
+	sendPaginated(cid, title, lines, 0)
+}
+
+// Lists all clips in the collection
+func listSounds(cid string, name string) {
+	coll := findCollection(name)
+	if coll == nil {
+		discord.ChannelMessageSend(cid, fmt.Sprintf("No collection called \"%s\".", name))
+		return
+	}
+
+	soundsMutex.RLock()
+	lines := []string{}
+	for _, sound := range coll.Sounds {
+		lines = append(lines, fmt.Sprintf("%s (%s)", sound.Name, formatDuration(sound.Duration())))
+	}
+	title := fmt.Sprintf(">>> **!%s - %d clips, %s:**", coll.Prefix, len(coll.Sounds), formatDuration(coll.Duration()))
+	soundsMutex.RUnlock()
+
+	sendPaginated(cid, title, lines, 0)
+}
+
+// Searches clips matching the query across all collections
+func searchSounds(cid string, query string) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		discord.ChannelMessageSend(cid, "Usage: `!search <text>`")
+		return
+	}
+
+	soundsMutex.RLock()
+	matches := []soundMatch{}
+	for _, coll := range COLLECTIONS {
+		for _, sound := range coll.Sounds {
+			if m, ok := matchSound(sound, query); ok {
+				matches = append(matches, m)
+			}
+		}
+	}
+
+	if len(matches) <= 0 {
+		soundsMutex.RUnlock()
+		discord.ChannelMessageSend(cid, fmt.Sprintf("No clips matching \"%s\".", query))
+		return
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].better(matches[j])
+	})
+
+	lines := []string{}
+	for _, m := range matches {
+		lines = append(lines, fmt.Sprintf("!%s %s (%s)", m.sound.Collection.Prefix, m.sound.Name, formatDuration(m.sound.Duration())))
+	}
+	soundsMutex.RUnlock()
+
+	sendPaginated(cid, fmt.Sprintf(">>> **%d clips matching \"%s\":**", len(matches), query), lines, 0)
+}
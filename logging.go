@@ -2,11 +2,24 @@ package main
 
 import (
 	"fmt"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 	redis "gopkg.in/redis.v3"
 )
 
+// Stats writes running in the background, waited for on shutdown
+var pendingStats sync.WaitGroup
+
+// Run the stats write in the background
+func trackAsync(f func()) {
+	pendingStats.Add(1)
+	go func() {
+		defer pendingStats.Done()
+		f()
+	}()
+}
+
 func trackSoundStats(play *Play) {
 	log.WithFields(log.Fields{
 		"guild":      play.Guild.Guild.Name,
@@ -43,6 +56,24 @@ func trackSoundStats(play *Play) {
 		pipe.SAdd(fmt.Sprintf("%s:users", base), play.User.ID)
 		pipe.SAdd(fmt.Sprintf("%s:guilds", base), play.Guild.Guild.ID)
 		pipe.SAdd(fmt.Sprintf("%s:channels", base), play.Channel.ID)
+
+		// Running totals, so stats can be read without scanning keys. Users are credited
+		// only with the clips they picked, like in the per-user keys the totals were migrated from.
+		if play.Forced {
+			pipe.Incr(userTotalKey(play.User.ID))
+			pipe.ZIncrBy(userSoundsKey(play.User.ID), 1, clipMember(play.Sound))
+		}
+		pipe.Incr(guildTotalKey(play.Guild.Guild.ID))
+
+		pipe.HIncrBy(clipCountersKey("", "plays"), clipMember(play.Sound), 1)
+		pipe.HIncrBy(clipCountersKey(play.Guild.Guild.ID, "plays"), clipMember(play.Sound), 1)
+
+		incrLeaderboards(pipe, play.Guild.Guild.ID, topClips, clipMember(play.Sound))
+		// Random clips, RNG4EVER included, are picked by the bot, so only specific plays count for users
+		if play.Forced {
+			incrLeaderboards(pipe, play.Guild.Guild.ID, topUsers, play.User.ID)
+		}
+		incrLeaderboards(pipe, play.Guild.Guild.ID, topCollections, play.Sound.Collection.Prefix)
 		return nil
 	})
 
@@ -53,10 +84,42 @@ func trackSoundStats(play *Play) {
 	}
 }
 
-func skipSound(sound *Sound) {
+// Key of the user's total plays of specific clips
+func userTotalKey(userID string) string {
+	return fmt.Sprintf("niksibot:user:%s:total", userID)
+}
+
+// Key of the sorted set of specific clips played by the user
+func userSoundsKey(userID string) string {
+	return fmt.Sprintf("niksibot:user:%s:sounds", userID)
+}
+
+// Key of the guild's total plays, the guild's clips are in its all-time leaderboard
+func guildTotalKey(guildID string) string {
+	return fmt.Sprintf("niksibot:guild:%s:total", guildID)
+}
+
+// Key of the per-clip counter hash, global or for the guild
+func clipCountersKey(guildID string, counter string) string {
+	if guildID == "" {
+		return fmt.Sprintf("niksibot:clips:%s", counter)
+	}
+	return fmt.Sprintf("niksibot:guild:%s:clips:%s", guildID, counter)
+}
+
+// Tracks a skip by a user, clips cut off by disconnecting aren't counted
+func trackSkipStats(play *Play) {
+	if play.SkippedBy == nil {
+		return
+	}
+	skipper := play.SkippedBy.ID
+
 	log.WithFields(log.Fields{
-		"sound":      sound.Name,
-		"collection": sound.Collection.Prefix,
+		"guild":      play.Guild.Guild.Name,
+		"sound":      play.Sound.Name,
+		"collection": play.Sound.Collection.Prefix,
+		"requester":  play.User.ID,
+		"skipper":    skipper,
 	}).Debug("Sound skipped")
 	if rcli == nil {
 		return
@@ -64,10 +127,18 @@ func skipSound(sound *Sound) {
 
 	_, err := rcli.Pipelined(func(pipe *redis.Pipeline) error {
 		base := "niksibot:skipped"
+		guildID := play.Guild.Guild.ID
 		pipe.Incr("niksibot:skipped:total")
-		// pipe.Incr(fmt.Sprintf("%s:user:%s:sound:%s", base, play.UserID, play.Sound.Name))
-		pipe.Incr(fmt.Sprintf("%s:sound:%s", base, sound.Name))
-		// pipe.Incr(fmt.Sprintf("%s:guild:%s:sound:%s", base, play.GuildID, play.Sound.Name))
+		pipe.Incr(fmt.Sprintf("%s:user:%s:sound:%s", base, play.User.ID, play.Sound.Name))
+		pipe.Incr(fmt.Sprintf("%s:sound:%s", base, play.Sound.Name))
+		pipe.Incr(fmt.Sprintf("%s:guild:%s:sound:%s", base, guildID, play.Sound.Name))
+		pipe.Incr(fmt.Sprintf("%s:collection:%s", base, play.Sound.Collection.Prefix))
+		pipe.Incr(fmt.Sprintf("%s:skipper:%s", base, skipper))
+
+		pipe.HIncrBy(clipCountersKey("", "skips"), clipMember(play.Sound), 1)
+		pipe.HIncrBy(clipCountersKey(guildID, "skips"), clipMember(play.Sound), 1)
+
+		incrLeaderboards(pipe, guildID, topSkipped, clipMember(play.Sound))
 		return nil
 	})
 
@@ -76,5 +147,4 @@ func skipSound(sound *Sound) {
 			"error": err,
 		}).Warning("Failed to track stats in redis")
 	}
-
 }
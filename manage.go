@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Extensions of the files that belong to a sound
+var soundFileExtensions = []string{".dca", ".aliases", ".weight", ".id", ".orig"}
+
+// Picks an ID for a new sound if its collection and name were used by a renamed sound,
+// so the new sound doesn't inherit the stats. Returns an empty string if no ID is needed.
+func newSoundID(coll *SoundCollection, name string) string {
+	member := coll.Prefix + "/" + name
+	if findClipMember(member) == nil {
+		return ""
+	}
+	return member + "#" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// Renames the sound, moving it to another collection if the collection is different.
+// The sound keeps its leaderboard stats, the legacy per-name counters start over.
+func renameSound(sound *Sound, coll *SoundCollection, name string) error {
+	soundsMutex.Lock()
+	defer soundsMutex.Unlock()
+
+	if coll.Taken(name) {
+		return fmt.Errorf("clip `%s` already exists in `!%s`", name, coll.Prefix)
+	}
+
+	old := sound.Collection
+	oldName := sound.Name
+
+	// Stats stay with the collection and name the sound had when it was first renamed
+	id := sound.ID
+	if id == "" {
+		id = clipMember(sound)
+	}
+
+	// Files already moved are moved back if any of them fails, so the sound isn't left in pieces
+	moved := map[string]string{}
+	rollback := func() {
+		for from, to := range moved {
+			os.Rename(to, from)
+		}
+	}
+
+	dir := filepath.Join(getConfig().AudioDir, coll.Prefix)
+	for _, ext := range soundFileExtensions {
+		from, to := sound.sidecarPath(ext), filepath.Join(dir, name+ext)
+		err := os.Rename(from, to)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			rollback()
+			return err
+		}
+		moved[from] = to
+	}
+
+	if err := writeSidecar(filepath.Join(dir, name+".id"), id); err != nil {
+		rollback()
+		return err
+	}
+
+	sound.ID = id
+	sound.Name = name
+	if coll != old {
+		old.Remove(sound)
+		coll.Add(sound)
+	}
+	renameIntroClips(old.Prefix, oldName, coll.Prefix, name)
+
+	log.WithFields(log.Fields{
+		"from": old.Prefix + "/" + oldName,
+		"to":   coll.Prefix + "/" + name,
+	}).Info("Sound renamed")
+	return nil
+}
+
+// Deletes the sound and its files, stats are kept
+func deleteSound(sound *Sound) error {
+	soundsMutex.Lock()
+	defer soundsMutex.Unlock()
+
+	for _, ext := range soundFileExtensions {
+		if err := os.Remove(sound.sidecarPath(ext)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	sound.Collection.Remove(sound)
+	clearIntroClips(sound.Collection.Prefix, sound.Name)
+
+	log.WithFields(log.Fields{
+		"collection": sound.Collection.Prefix,
+		"sound":      sound.Name,
+	}).Info("Sound deleted")
+	return nil
+}
+
+// Changes how likely the sound is picked as a random clip
+func setSoundWeight(sound *Sound, weight int) error {
+	soundsMutex.Lock()
+	defer soundsMutex.Unlock()
+
+	value := ""
+	if weight != 1 {
+		value = strconv.Itoa(weight)
+	}
+	if err := writeSidecar(sound.sidecarPath(".weight"), value); err != nil {
+		return err
+	}
+
+	sound.Collection.soundRange += weight - sound.Weight
+	sound.Weight = weight
+	return nil
+}
+
+// Replaces the sound's aliases, an empty list removes them
+func setSoundAliases(sound *Sound, aliases []string) error {
+	soundsMutex.Lock()
+	defer soundsMutex.Unlock()
+
+	for _, alias := range aliases {
+		for _, s := range sound.Collection.Sounds {
+			if s != sound && scontains(alias, s.Keys()...) {
+				return fmt.Errorf("`%s` is already used by `%s`", alias, s.Name)
+			}
+		}
+	}
+
+	if err := writeSidecar(sound.sidecarPath(".aliases"), strings.Join(aliases, "\n")); err != nil {
+		return err
+	}
+	sound.Aliases = aliases
+	return nil
+}
+
+// Finds the clip given in the command, the name must be exact as the clip is modified
+func findExactClip(cid string, collection string, query string) *Sound {
+	coll := findCollection(collection)
+	if coll == nil {
+		discord.ChannelMessageSend(cid, fmt.Sprintf("No collection called \"%s\".", collection))
+		return nil
+	}
+
+	sound, suggestions := coll.Find(query)
+	if sound == nil {
+		sendSuggestions(cid, coll, query, suggestions)
+		return nil
+	}
+	if !scontains(strings.ToLower(query), sound.Keys()...) {
+		discord.ChannelMessageSend(cid, fmt.Sprintf("Did you mean `%s`? Give the exact name of the clip.", sound.Name))
+		return nil
+	}
+	return sound
+}
+
+// Handles the !clip command
+func handleClipCommand(m *discordgo.MessageCreate, parts []string) {
+	usage := "Usage: `!clip rename <COLLECTION> <CLIP> <NAME>`, `!clip move <COLLECTION> <CLIP> <COLLECTION>`, " +
+		"`!clip delete <COLLECTION> <CLIP>`, `!clip weight <COLLECTION> <CLIP> <N>`, `!clip aliases <COLLECTION> <CLIP> [ALIAS...]`"
+	if len(parts) < 4 || !scontains(parts[1], "rename", "move", "delete", "weight", "aliases") {
+		discord.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+
+	// Collections are shared by all guilds, so only the owner can change them
+	if m.Author.ID != getConfig().Owner {
+		discord.ChannelMessageSend(m.ChannelID, "Only the bot owner can manage clips.")
+		return
+	}
+
+	sound := findExactClip(m.ChannelID, parts[2], parts[3])
+	if sound == nil {
+		return
+	}
+	args := parts[4:]
+
+	var (
+		err   error
+		reply string
+	)
+	switch parts[1] {
+	case "rename":
+		name := ""
+		if len(args) > 0 {
+			name = soundName(strings.Join(args, "_"))
+		}
+		if name == "" {
+			discord.ChannelMessageSend(m.ChannelID, "Usage: `!clip rename <COLLECTION> <CLIP> <NAME>`")
+			return
+		}
+		err = renameSound(sound, sound.Collection, name)
+		reply = fmt.Sprintf("Renamed to `!%s %s`.", sound.Collection.Prefix, name)
+	case "move":
+		if len(args) != 1 {
+			discord.ChannelMessageSend(m.ChannelID, "Usage: `!clip move <COLLECTION> <CLIP> <COLLECTION>`")
+			return
+		}
+		coll := findCollection(args[0])
+		if coll == nil {
+			discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No collection called \"%s\".", args[0]))
+			return
+		}
+		err = renameSound(sound, coll, sound.Name)
+		reply = fmt.Sprintf("Moved to `!%s %s`.", coll.Prefix, sound.Name)
+	case "delete":
+		err = deleteSound(sound)
+		reply = fmt.Sprintf("Deleted `!%s %s`.", sound.Collection.Prefix, sound.Name)
+	case "weight":
+		weight := 0
+		if len(args) == 1 {
+			weight, _ = strconv.Atoi(args[0])
+		}
+		if weight < 1 {
+			discord.ChannelMessageSend(m.ChannelID, "Weight must be a whole number of at least 1.")
+			return
+		}
+		err = setSoundWeight(sound, weight)
+		reply = fmt.Sprintf("Weight of `!%s %s` is now %d.", sound.Collection.Prefix, sound.Name, weight)
+	case "aliases":
+		err = setSoundAliases(sound, args)
+		reply = fmt.Sprintf("Aliases of `!%s %s` were cleared.", sound.Collection.Prefix, sound.Name)
+		if len(args) > 0 {
+			reply = fmt.Sprintf("Aliases of `!%s %s` are now %s.", sound.Collection.Prefix, sound.Name, strings.Join(args, ", "))
+		}
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"command": parts[1],
+			"sound":   sound.Name,
+			"error":   err,
+		}).Warning("Failed to manage clip")
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Failed: %v", err))
+		return
+	}
+	discord.ChannelMessageSend(m.ChannelID, reply)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Maximum number of suggestions given when a clip name is ambiguous
+const MAX_SUGGESTIONS = 5
+
+// Match kinds, ordered from the strongest to the weakest
+const (
+	matchExact = iota
+	matchPrefix
+	matchSubstring
+	matchFuzzy
+)
+
+// soundMatch describes how well a sound matched the query
+type soundMatch struct {
+	sound    *Sound
+	kind     int
+	distance int
+}
+
+// better reports whether m is a stronger match than o
+func (m soundMatch) better(o soundMatch) bool {
+	if m.kind != o.kind {
+		return m.kind < o.kind
+	}
+	return m.distance < o.distance
+}
+
+// Find looks up a sound by its name or one of its aliases.
+// Exact matches win, otherwise prefix, substring and edit distance matches are tried in that order.
+// Returns the sound if it's the only match of the best kind (names are unique, so exact matches are),
+// otherwise the closest candidates as suggestions.
+func (sc *SoundCollection) Find(query string) (*Sound, []*Sound) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil, nil
+	}
+
+	soundsMutex.RLock()
+	matches := []soundMatch{}
+	for _, sound := range sc.Sounds {
+		if m, ok := matchSound(sound, query); ok {
+			matches = append(matches, m)
+		}
+	}
+	soundsMutex.RUnlock()
+
+	if len(matches) <= 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].better(matches[j]) {
+			return true
+		}
+		if matches[j].better(matches[i]) {
+			return false
+		}
+		return matches[i].sound.Name < matches[j].sound.Name
+	})
+
+	// Unambiguous when nothing else matched the same way, the distance only orders the suggestions
+	if len(matches) == 1 || matches[1].kind != matches[0].kind {
+		return matches[0].sound, nil
+	}
+
+	suggestions := []*Sound{}
+	for i := 0; i < len(matches) && i < MAX_SUGGESTIONS; i++ {
+		suggestions = append(suggestions, matches[i].sound)
+	}
+	return nil, suggestions
+}
+
+// Matches the query against the sound's name and aliases, returning the strongest match
+func matchSound(sound *Sound, query string) (soundMatch, bool) {
+	var (
+		best  soundMatch
+		found bool
+	)
+
+	for _, key := range sound.Keys() {
+		m := soundMatch{sound: sound}
+
+		switch {
+		case key == query:
+			m.kind = matchExact
+		case strings.HasPrefix(key, query):
+			m.kind = matchPrefix
+			m.distance = utf8.RuneCountInString(key) - utf8.RuneCountInString(query)
+		case strings.Contains(key, query):
+			m.kind = matchSubstring
+			m.distance = utf8.RuneCountInString(key) - utf8.RuneCountInString(query)
+		default:
+			m.kind = matchFuzzy
+			m.distance = levenshtein(key, query)
+			if m.distance > fuzzyThreshold(query) {
+				continue
+			}
+		}
+
+		if !found || m.better(best) {
+			best = m
+			found = true
+		}
+	}
+	return best, found
+}
+
+// Keys returns the lowercase name and aliases the sound can be requested with
+func (s *Sound) Keys() []string {
+	keys := []string{strings.ToLower(s.Name)}
+	for _, alias := range s.Aliases {
+		keys = append(keys, strings.ToLower(alias))
+	}
+	return keys
+}
+
+// Maximum edit distance accepted for the query, roughly one typo per three characters
+func fuzzyThreshold(query string) int {
+	return len([]rune(query))/3 + 1
+}
+
+// Computes the Levenshtein distance between the two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Tells the user that the clip was not found, suggesting close matches if there are any
+func sendSuggestions(cid string, coll *SoundCollection, query string, suggestions []*Sound) {
+	if len(suggestions) <= 0 {
+		discord.ChannelMessageSend(cid, fmt.Sprintf("No clip matching \"%s\" in !%s.", query, coll.Prefix))
+		return
+	}
+
+	names := []string{}
+	for _, sound := range suggestions {
+		names = append(names, fmt.Sprintf("`!%s %s`", coll.Prefix, sound.Name))
+	}
+	discord.ChannelMessageSend(cid, fmt.Sprintf("Did you mean %s?", strings.Join(names, ", ")))
+}
@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"airhorn", "airhorn", 0},
+		{"airhorn", "", 7},
+		{"", "horn", 4},
+		{"airhorn", "airhron", 2},
+		{"kitten", "sitting", 3},
+		{"äänet", "aanet", 2},
+	}
+
+	for _, test := range tests {
+		if got := levenshtein(test.a, test.b); got != test.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	coll := &SoundCollection{
+		Prefix: "test",
+		Sounds: []*Sound{
+			{Name: "airhorn"},
+			{Name: "airhorn_long"},
+			{Name: "horn", Aliases: []string{"honk"}},
+			{Name: "sad_trombone"},
+			{Name: "trombone_solo"},
+			{Name: "bell_a"},
+			{Name: "bell_b"},
+			{Name: "airraid"},
+			{Name: "äänet"},
+			{Name: "äänetön"},
+		},
+	}
+
+	tests := []struct {
+		query       string
+		want        string
+		suggestions []string
+	}{
+		{"airhorn", "airhorn", nil},
+		{"AirHorn ", "airhorn", nil},
+		{"honk", "horn", nil},
+		{"airhorn_l", "airhorn_long", nil},
+		{"air", "", []string{"airhorn", "airraid", "airhorn_long"}},
+		{"airh", "", []string{"airhorn", "airhorn_long"}},
+		{"äänet", "äänet", nil},
+		{"ääne", "", []string{"äänet", "äänetön"}},
+		{"sad", "sad_trombone", nil},
+		{"trombone", "trombone_solo", nil},
+		{"bell", "", []string{"bell_a", "bell_b"}},
+		{"airhron", "airhorn", nil},
+		{"kazoo", "", nil},
+		{"", "", nil},
+	}
+
+	for _, test := range tests {
+		sound, suggestions := coll.Find(test.query)
+
+		got := ""
+		if sound != nil {
+			got = sound.Name
+		}
+		names := []string{}
+		for _, s := range suggestions {
+			names = append(names, s.Name)
+		}
+
+		if got != test.want || len(names) != len(test.suggestions) {
+			t.Errorf("Find(%q) = %q, %q, want %q, %q", test.query, got, names, test.want, test.suggestions)
+			continue
+		}
+		for i := range names {
+			if names[i] != test.suggestions[i] {
+				t.Errorf("Find(%q) suggested %q, want %q", test.query, names, test.suggestions)
+				break
+			}
+		}
+	}
+}
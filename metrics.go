@@ -0,0 +1,107 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricPlays = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "niksibot_plays_total",
+		Help: "Number of plays started, by collection and whether the clip was random or specific.",
+	}, []string{"collection", "kind"})
+
+	metricSkips = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "niksibot_skips_total",
+		Help: "Number of plays skipped by users, by collection.",
+	}, []string{"collection"})
+
+	metricCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "niksibot_commands_total",
+		Help: "Number of commands received, by command.",
+	}, []string{"command"})
+
+	metricVoiceJoin = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "niksibot_voice_join_seconds",
+		Help:    "Time taken to join a voice channel.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+
+	metricTranscodeSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "niksibot_transcode_seconds_total",
+		Help: "CPU time spent on decoding, changing and encoding audio while playing, by reason.",
+	}, []string{"reason"})
+
+	metricTranscodedFrames = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "niksibot_transcoded_frames_total",
+		Help: "Number of Opus frames transcoded while playing, by reason.",
+	}, []string{"reason"})
+
+	metricOpusSend = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "niksibot_opus_send_seconds",
+		Help:    "Time an Opus frame waited to be accepted by the voice connection, long waits are stalls.",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.04, 0.08, 0.16, 0.32, 0.64, 1.28},
+	})
+)
+
+func init() {
+	prometheus.MustRegister(metricPlays, metricSkips, metricCommands, metricVoiceJoin, metricOpusSend)
+	prometheus.MustRegister(metricTranscodeSeconds, metricTranscodedFrames)
+
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "niksibot_voice_connections",
+		Help: "Number of guilds with a voice connection.",
+	}, func() float64 {
+		return float64(connectedGuilds())
+	}))
+
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "niksibot_queue_depth",
+		Help: "Number of plays waiting in all guild queues.",
+	}, func() float64 {
+		depth := 0
+		for _, g := range allGuilds() {
+			depth += len(g.Queued())
+		}
+		return float64(depth)
+	}))
+
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "niksibot_sounds_loaded",
+		Help: "Number of sounds loaded.",
+	}, func() float64 {
+		soundsMutex.RLock()
+		defer soundsMutex.RUnlock()
+		return float64(SoundCount)
+	}))
+
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "niksibot_sound_buffer_bytes",
+		Help: "Size of the Opus frames of all loaded sounds.",
+	}, func() float64 {
+		soundsMutex.RLock()
+		defer soundsMutex.RUnlock()
+
+		size := 0
+		for _, coll := range COLLECTIONS {
+			for _, sound := range coll.Sounds {
+				size += sound.Size()
+			}
+		}
+		return float64(size)
+	}))
+}
+
+// Counts the play in metrics
+func countPlay(play *Play) {
+	kind := "random"
+	if play.Forced {
+		kind = "specific"
+	}
+	metricPlays.WithLabelValues(play.Sound.Collection.Prefix, kind).Inc()
+}
+
+// Counts the command in metrics by the name of the branch handling it, plays are counted as "play"
+// and anything else as "unknown", so the number of labels stays small
+func countCommand(name string) {
+	metricCommands.WithLabelValues(name).Inc()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	redis "gopkg.in/redis.v3"
+)
+
+// Number of keys fetched per SCAN and MGET round
+const MIGRATE_BATCH_SIZE = 500
+
+// Set once the stats have been migrated, running the migration again would overwrite newer counts
+const migratedKey = "niksibot:migrated"
+
+// Scans keys matching the pattern without blocking redis, and calls fn with each key and its value
+func scanCounters(pattern string, fn func(key string, value int)) error {
+	var cursor int64
+	for {
+		next, keys, err := rcli.Scan(cursor, pattern, MIGRATE_BATCH_SIZE).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			values, err := rcli.MGet(keys...).Result()
+			if err != nil {
+				return err
+			}
+
+			for i, v := range values {
+				if str, ok := v.(string); ok {
+					n, _ := strconv.Atoi(str)
+					fn(keys[i], n)
+				}
+			}
+		}
+
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Guess the collection of a sound known only by its name, old keys don't include the collection.
+// Returns false if several collections have a sound with the name, so the plays can't be attributed.
+func guessClipMember(name string) (string, bool) {
+	member := "?/" + name
+	found := false
+	for _, coll := range COLLECTIONS {
+		if coll.Sound(name) == nil {
+			continue
+		}
+		if found {
+			return "", false
+		}
+		member = coll.Prefix + "/" + name
+		found = true
+	}
+	return member, true
+}
+
+// Adds n plays of the clip to the owner's totals
+func addCounts(totals map[string]int, sounds map[string]map[string]int, owner string, member string, n int) {
+	totals[owner] += n
+	if sounds[owner] == nil {
+		sounds[owner] = make(map[string]int)
+	}
+	sounds[owner][member] += n
+}
+
+// migrateStats backfills the user and guild totals and sorted sets from the per-sound keys.
+// Existing values are overwritten, so the migration is only run once and must be run
+// before the bot counts plays in the new keys.
+func migrateStats() error {
+	migrated, err := rcli.Exists(migratedKey).Result()
+	if err != nil {
+		return err
+	}
+	if migrated {
+		log.Info("Stats have already been migrated")
+		return nil
+	}
+
+	var (
+		userTotals  = make(map[string]int)
+		userSounds  = make(map[string]map[string]int)
+		guildTotals = make(map[string]int)
+		guildSounds = make(map[string]map[string]int)
+
+		// Clip names found in several collections, their plays are left out
+		ambiguous = make(map[string]int)
+	)
+
+	// Adds the plays counted in the key to the owner's totals, unless the clip is ambiguous
+	count := func(totals map[string]int, sounds map[string]map[string]int, key string, n int) {
+		parts := strings.SplitN(key, ":", 6)
+		if len(parts) != 6 {
+			return
+		}
+		member, ok := guessClipMember(parts[5])
+		if !ok {
+			ambiguous[parts[5]] += n
+			return
+		}
+		addCounts(totals, sounds, parts[3], member, n)
+	}
+
+	// Only specific plays were counted per user: niksibot:specific:user:<user>:sound:<sound>
+	err = scanCounters("niksibot:specific:user:*:sound:*", func(key string, n int) {
+		count(userTotals, userSounds, key, n)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Guild plays: niksibot:<random|specific>:guild:<guild>:sound:<sound>
+	for _, base := range []string{"random", "specific"} {
+		err := scanCounters(fmt.Sprintf("niksibot:%s:guild:*:sound:*", base), func(key string, n int) {
+			count(guildTotals, guildSounds, key, n)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = rcli.Pipelined(func(pipe *redis.Pipeline) error {
+		for user, total := range userTotals {
+			pipe.Set(userTotalKey(user), total, 0)
+			for member, n := range userSounds[user] {
+				pipe.ZAdd(userSoundsKey(user), redis.Z{Score: float64(n), Member: member})
+			}
+		}
+
+		for guild, total := range guildTotals {
+			pipe.Set(guildTotalKey(guild), total, 0)
+			for member, n := range guildSounds[guild] {
+				pipe.ZAdd(leaderboardKey(guild, topClips, "all"), redis.Z{Score: float64(n), Member: member})
+			}
+		}
+		pipe.Set(migratedKey, "1", 0)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for name, n := range ambiguous {
+		log.WithFields(log.Fields{
+			"sound": name,
+			"plays": n,
+		}).Warning("Clip name is used in several collections, its plays were left out")
+	}
+
+	log.WithFields(log.Fields{
+		"users":  len(userTotals),
+		"guilds": len(guildTotals),
+	}).Info("Stats migrated")
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Most clips mixed on top of the playing clip at once, more are queued
+const MAX_OVERLAYS = 4
+
+// Overlay is a clip mixed on top of the playing clip
+type Overlay struct {
+	Play *Play
+
+	// Decoded audio of the clip and the position of the next sample to mix
+	pcm []int16
+	pos int
+
+	entry *HistoryEntry
+}
+
+// Tries to mix the play on top of the clip being played, returns false if the play should be queued instead
+func (g *Guild) Overlay(play *Play) bool {
+	vc := g.VoiceConnection
+	if !g.Settings().Overlay || g.NowPlaying() == nil || vc == nil || vc.ChannelID != play.Channel.ID {
+		return false
+	}
+
+	// The length is checked before the effects are rendered, rendering long clips takes a while
+	frames := play.Sound.Frames()
+	length := time.Duration(float64(len(frames))*play.Effects.Scale()) * FRAME_DURATION
+	if length > getConfig().Overlay.MaxLength {
+		return false
+	}
+	if !play.Effects.None() {
+		rendered, err := play.Effects.Render(frames)
+		if err != nil {
+			return false
+		}
+		frames = rendered
+	}
+
+	pcm, err := decodeOpus(frames)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"sound": play.Sound.Name,
+			"error": err,
+		}).Warning("Failed to decode overlay, queuing it")
+		return false
+	}
+
+	// The clip may have ended meanwhile
+	g.overlayMutex.Lock()
+	if g.Playing == nil || len(g.overlays) >= MAX_OVERLAYS {
+		g.overlayMutex.Unlock()
+		return false
+	}
+
+	overlay := &Overlay{Play: play, pcm: pcm}
+	g.overlays = append(g.overlays, overlay)
+	g.overlayMutex.Unlock()
+
+	// Overlays are counted like any other play
+	overlay.entry = g.SaveToHistory(play)
+	trackAsync(func() { trackSoundStats(play) })
+	countPlay(play)
+	publishPlay(eventStarted, play)
+	return true
+}
+
+// Mixing reports whether the playing clip has to be mixed with overlays or brought back from ducking
+func (g *Guild) Mixing() bool {
+	if g == nil {
+		return false
+	}
+
+	g.overlayMutex.Lock()
+	defer g.overlayMutex.Unlock()
+	return len(g.overlays) > 0 || g.ducking > 0
+}
+
+// Mixes the overlays into a frame of the playing clip, turning the playing clip down while overlays are playing
+func (g *Guild) mixOverlays(pcm []int16) {
+	g.overlayMutex.Lock()
+	defer g.overlayMutex.Unlock()
+
+	// Ducking is ramped over the frame so the volume change doesn't click
+	target := 0.0
+	if len(g.overlays) > 0 {
+		target = 1
+	}
+	duck := float64(getConfig().Overlay.Duck) / 100
+	samples := len(pcm) / AUDIO_CHANNELS
+
+	for i := 0; i < samples; i++ {
+		ducking := g.ducking + (target-g.ducking)*float64(i+1)/float64(samples)
+		gain := 1 - ducking*(1-duck)
+
+		for c := 0; c < AUDIO_CHANNELS; c++ {
+			sample := float64(pcm[i*AUDIO_CHANNELS+c]) * gain
+			for _, overlay := range g.overlays {
+				if j := overlay.pos + i*AUDIO_CHANNELS + c; j < len(overlay.pcm) {
+					sample += float64(overlay.pcm[j])
+				}
+			}
+			pcm[i*AUDIO_CHANNELS+c] = clampSample(sample)
+		}
+	}
+	g.ducking = target
+
+	playing := []*Overlay{}
+	for _, overlay := range g.overlays {
+		overlay.pos += len(pcm)
+		if overlay.pos < len(overlay.pcm) {
+			playing = append(playing, overlay)
+			continue
+		}
+
+		publishPlay(eventFinished, overlay.Play)
+		go g.saveHistory()
+	}
+	g.overlays = playing
+}
+
+// Plays the rest of the overlays by themselves after the playing clip has ended.
+// Skipping now skips the overlays, not the next clip.
+func (g *Guild) finishOverlays() {
+	vc := g.VoiceConnection
+	if vc == nil || !g.Mixing() {
+		return
+	}
+
+	vc.Speaking(true)
+	defer vc.Speaking(false)
+
+	transcoder, err := newTranscoder("overlay")
+	for err == nil && g.Mixing() && !g.SkipPending && !g.DisconnectPending {
+		pcm := make([]int16, AUDIO_FRAME_SIZE*AUDIO_CHANNELS)
+		g.mixOverlays(pcm)
+		applyVolume(pcm, g.Volume())
+
+		var frame []byte
+		if frame, err = transcoder.Encode(pcm); err == nil {
+			metricTranscodedFrames.WithLabelValues("overlay").Inc()
+			vc.OpusSend <- frame
+		}
+	}
+
+	if g.SkipPending {
+		g.SkipPending = false
+		g.SkipUser = nil
+	}
+	g.clearOverlays()
+}
+
+// Drops the overlays that are still playing, they are marked skipped
+func (g *Guild) clearOverlays() {
+	g.overlayMutex.Lock()
+	overlays := g.overlays
+	g.overlays = nil
+	g.ducking = 0
+	g.overlayMutex.Unlock()
+
+	for _, overlay := range overlays {
+		overlay.Play.Skipped = true
+		g.markSkipped(overlay.entry, nil)
+		publishPlay(eventSkipped, overlay.Play)
+	}
+	if len(overlays) > 0 {
+		g.saveHistory()
+	}
+}
+
+// Handles the !overlay [on|off] command
+func handleOverlayCommand(m *discordgo.MessageCreate, parts []string, guild *discordgo.Guild) {
+	if len(parts) < 2 {
+		state := "off"
+		if getGuild(guild).Settings().Overlay {
+			state = "on"
+		}
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Overlay is %s. Usage: `!overlay on|off`", state))
+		return
+	}
+
+	if !scontains(parts[1], "on", "off") {
+		discord.ChannelMessageSend(m.ChannelID, "Usage: `!overlay on|off`")
+		return
+	}
+
+	if !isGuildAdmin(m.Author.ID, m.ChannelID) {
+		discord.ChannelMessageSend(m.ChannelID, "Only server admins can change the overlay setting.")
+		return
+	}
+
+	settingsMutex.Lock()
+	s := getSettings(guild.ID)
+	overlay := parts[1] == "on"
+	s.Overlay = &overlay
+	saveSettings()
+	settingsMutex.Unlock()
+
+	discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Overlay is now %s.", parts[1]))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Pagination settings
+const (
+	MAX_MESSAGE_LENGTH = 2000
+	PAGINATOR_TIMEOUT  = 10 * time.Minute
+
+	reactionPrevious = "◀"
+	reactionNext     = "▶"
+)
+
+// Paginator holds the pages of a long reply that can be browsed with reactions
+type Paginator struct {
+	ChannelID string
+	MessageID string
+	Pages     []string
+	Page      int
+}
+
+var (
+	// Paginated messages by message ID
+	paginators      = make(map[string]*Paginator)
+	paginatorsMutex sync.Mutex
+)
+
+// Splits the lines to pages that fit in a single Discord message, with at most perPage lines (unless zero)
+func paginate(header string, lines []string, perPage int) []string {
+	// Reserve room for the header and the page footer
+	limit := MAX_MESSAGE_LENGTH - len(header) - 32
+
+	pages := []string{}
+	page := ""
+	count := 0
+	for _, line := range lines {
+		// Cut lines leave room for the ellipsis and the line break
+		if len(line)+1 > limit {
+			line = truncate(line, limit-4) + "..."
+		}
+
+		if page != "" && (len(page)+len(line)+1 > limit || (perPage > 0 && count >= perPage)) {
+			pages = append(pages, page)
+			page = ""
+			count = 0
+		}
+		page += line + "\n"
+		count++
+	}
+
+	if page != "" || len(pages) <= 0 {
+		pages = append(pages, page)
+	}
+
+	for i := range pages {
+		pages[i] = header + "\n" + pages[i]
+		if len(pages) > 1 {
+			pages[i] += fmt.Sprintf("\nPage %d/%d", i+1, len(pages))
+		}
+	}
+	return pages
+}
+
+// Cuts the string to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Sends the lines to the channel, adding navigation reactions if they don't fit in one message
+func sendPaginated(cid string, header string, lines []string, perPage int) {
+	pages := paginate(header, lines, perPage)
+
+	msg, err := discord.ChannelMessageSend(cid, pages[0])
+	if err != nil {
+		log.WithFields(log.Fields{
+			"channel": cid,
+			"error":   err,
+		}).Warning("Failed to send paginated message")
+		return
+	}
+
+	if len(pages) <= 1 {
+		return
+	}
+
+	paginatorsMutex.Lock()
+	paginators[msg.ID] = &Paginator{
+		ChannelID: cid,
+		MessageID: msg.ID,
+		Pages:     pages,
+	}
+	paginatorsMutex.Unlock()
+
+	discord.MessageReactionAdd(cid, msg.ID, reactionPrevious)
+	discord.MessageReactionAdd(cid, msg.ID, reactionNext)
+
+	// Stop following reactions after a while
+	time.AfterFunc(PAGINATOR_TIMEOUT, func() {
+		paginatorsMutex.Lock()
+		delete(paginators, msg.ID)
+		paginatorsMutex.Unlock()
+	})
+}
+
+func onMessageReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.UserID == s.State.Ready.User.ID {
+		return
+	}
+
+	delta := 0
+	switch r.Emoji.Name {
+	case reactionPrevious:
+		delta = -1
+	case reactionNext:
+		delta = 1
+	default:
+		return
+	}
+
+	// The page is turned under the lock, the messages are sent after releasing it
+	paginatorsMutex.Lock()
+	p := paginators[r.MessageID]
+	if p == nil {
+		paginatorsMutex.Unlock()
+		return
+	}
+	channelID, messageID := p.ChannelID, p.MessageID
+	page := p.Page + delta
+	content := ""
+	if page >= 0 && page < len(p.Pages) {
+		p.Page = page
+		content = p.Pages[page]
+	}
+	paginatorsMutex.Unlock()
+
+	// Remove the reaction so the same button can be pressed again
+	s.MessageReactionRemove(channelID, messageID, r.Emoji.Name, r.UserID)
+
+	if content != "" {
+		s.ChannelMessageEdit(channelID, messageID, content)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"airhorn", 10, "airhorn"},
+		{"airhorn", 7, "airhorn"},
+		{"airhorn", 3, "air"},
+		{"äänet", 3, "ä"},
+		{"äänet", 4, "ää"},
+		{"äänet", 1, ""},
+		{"", 0, ""},
+	}
+
+	for _, test := range tests {
+		if got := truncate(test.s, test.n); got != test.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", test.s, test.n, got, test.want)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 30; i++ {
+		lines = append(lines, strings.Repeat("ö", 200))
+	}
+
+	pages := paginate("**Header**", lines, 0)
+	if len(pages) < 2 {
+		t.Fatalf("paginate() gave %d pages, want several", len(pages))
+	}
+	for i, page := range pages {
+		if len(page) > MAX_MESSAGE_LENGTH || !utf8.ValidString(page) || !strings.HasPrefix(page, "**Header**\n") {
+			t.Errorf("page %d is invalid: %d bytes, valid UTF-8 %v", i, len(page), utf8.ValidString(page))
+		}
+	}
+
+	// Lines longer than a message are cut without splitting characters
+	pages = paginate("**Header**", []string{strings.Repeat("ö", MAX_MESSAGE_LENGTH)}, 0)
+	if len(pages) != 1 || len(pages[0]) > MAX_MESSAGE_LENGTH || !utf8.ValidString(pages[0]) || !strings.Contains(pages[0], "...") {
+		t.Errorf("paginate() of a long line = %d pages, first %d bytes", len(pages), len(pages[0]))
+	}
+
+	short := []string{}
+	for i := 0; i < 30; i++ {
+		short = append(short, "clip")
+	}
+	// Cut lines take a page of their own, without empty pages around them
+	for _, long := range [][]string{
+		{strings.Repeat("a", MAX_MESSAGE_LENGTH)},
+		{strings.Repeat("a", MAX_MESSAGE_LENGTH-len("**Header**")-32)},
+		{strings.Repeat("a", MAX_MESSAGE_LENGTH-len("**Header**")-33)},
+		{"clip", strings.Repeat("a", MAX_MESSAGE_LENGTH), "clip"},
+	} {
+		pages := paginate("**Header**", long, 0)
+		for i, page := range pages {
+			if strings.TrimSpace(strings.TrimPrefix(strings.Split(page, "\nPage")[0], "**Header**")) == "" {
+				t.Errorf("paginate() of %d lines gave empty page %d of %d", len(long), i+1, len(pages))
+			}
+			if len(page) > MAX_MESSAGE_LENGTH {
+				t.Errorf("paginate() of %d lines gave page %d of %d bytes", len(long), i+1, len(page))
+			}
+		}
+	}
+
+	if pages := paginate("**Header**", short, 5); len(pages) != 6 {
+		t.Errorf("paginate() with 5 lines per page gave %d pages, want 6", len(pages))
+	}
+}
@@ -9,8 +9,43 @@ import (
 // Player will handle playing sounds to the guild.
 // Exits after bot disconnects from the guild.
 func (g *Guild) Player() {
-	for len(g.Queue) > 0 {
-		play := <-g.Queue
+	for {
+		// a crossfade has already started the next play in the current channel
+		fade := g.crossfade
+		g.crossfade = nil
+
+		var play *Play
+		if fade != nil {
+			play = fade.Play
+		} else {
+			play = g.Next()
+		}
+		if play == nil {
+			break
+		}
+
+		// resolve the channel at play time according to the follow policy
+		if fade == nil {
+			channel := g.ResolveChannel(play)
+			if channel == nil {
+				log.WithFields(log.Fields{
+					"guild": g.Guild.Name,
+					"user":  play.User.ID,
+					"sound": play.Sound.Name,
+				}).Info("Requester has left voice, dropping play")
+
+				// random plays would be picked for the same requester and dropped again
+				if g.State == RNG4EVER {
+					log.WithFields(log.Fields{
+						"guild": g.Guild.Name,
+						"user":  play.User.ID,
+					}).Info("Requester of RNG4EVER has left voice, leaving RNG4EVER mode")
+					g.SetMode(0)
+				}
+				continue
+			}
+			play.Channel = channel
+		}
 
 		// connect to voice if necessary
 		if g.VoiceConnection == nil {
@@ -18,7 +53,9 @@ func (g *Guild) Player() {
 				"guild":   g.Guild.Name,
 				"channel": play.Channel.Name,
 			}).Debug("Attempting voice connection")
+			start := time.Now()
 			vc, err := discord.ChannelVoiceJoin(g.Guild.ID, play.Channel.ID, false, false)
+			metricVoiceJoin.Observe(time.Since(start).Seconds())
 
 			if err != nil {
 				log.WithFields(log.Fields{
@@ -26,6 +63,8 @@ func (g *Guild) Player() {
 					"channel": play.Channel.Name,
 					"error":   err,
 				}).Error("Voice connection failed")
+				publish(Event{Type: eventDisconnected, Guild: g.Guild.ID, Reason: reasonVoiceError})
+				g.Reset()
 				return
 			}
 
@@ -33,7 +72,10 @@ func (g *Guild) Player() {
 				"guild":   g.Guild.Name,
 				"channel": play.Channel.Name,
 			}).Debug("Voice connected")
+			g.voiceMutex.Lock()
 			g.VoiceConnection = vc
+			g.voiceMutex.Unlock()
+			g.CheckAlone()
 		} else if g.VoiceConnection.ChannelID != play.Channel.ID {
 			// change channel if necessary
 			log.WithFields(log.Fields{
@@ -42,30 +84,96 @@ func (g *Guild) Player() {
 			}).Debug("Changing voice channel")
 			g.VoiceConnection.ChangeChannel(play.Channel.ID, false, false)
 			time.Sleep(time.Millisecond * 125)
+			g.CheckAlone()
 		}
 
 		// save stats
-		g.SaveToHistory(play)
-		go trackSoundStats(play)
+		entry := g.SaveToHistory(play)
+		trackAsync(func() { trackSoundStats(play) })
+		countPlay(play)
+
+		// play sound, or the rest of it after the crossfade
+		var frames [][]byte
+		if fade != nil {
+			frames = fade.rest()
+		} else {
+			frames = play.Sound.Render(play.Effects)
+			time.Sleep(time.Millisecond * 32)
+		}
+		g.setPlaying(play)
+		saveState()
+		publishPlay(eventStarted, play)
+		play.Skipped = play.Sound.Play(g.VoiceConnection, frames)
+		g.setPlaying(nil)
+
+		// overlays end with the playing clip, unless a crossfade carries them over to the next one
+		if play.Skipped || g.DisconnectPending {
+			g.clearOverlays()
+		} else if g.crossfade == nil {
+			g.finishOverlays()
+		}
+
+		if play.Skipped {
+			play.SkippedBy = g.SkipUser
+			g.SkipUser = nil
 
-		// play sound
-		time.Sleep(time.Millisecond * 32)
-		play.Skipped = play.Sound.Play(g.VoiceConnection)
+			// only skips by users count in the stats, not clips cut off by !dd, the alone timer or shutdown
+			if play.SkippedBy != nil && !g.DisconnectPending {
+				trackAsync(func() { trackSkipStats(play) })
+				metricSkips.WithLabelValues(play.Sound.Collection.Prefix).Inc()
+			}
+			publishPlay(eventSkipped, play)
+		} else {
+			publishPlay(eventFinished, play)
+		}
+
+		if play.Skipped {
+			g.markSkipped(entry, play.SkippedBy)
+		}
+		g.saveHistory()
 
 		// disconnect if we have forced disconnect pending
 		if g.DisconnectPending {
+			g.dropCrossfade()
 			break
 		}
 
-		// enqueue random sound if necessary when state is RNG4EVER
-		if g.State == RNG4EVER && len(g.Queue) <= 0 {
-			enqueuePlay(play.User, play.Guild.Guild, play.Sound.Collection, nil)
-		}
+		g.continueRandom(play)
+	}
+
+	if g.DisconnectReason == "" {
+		g.DisconnectReason = reasonQueueEmpty
 	}
 
 	log.WithFields(log.Fields{
-		"guild": g.Guild.Name,
-		"force": g.DisconnectPending,
+		"guild":  g.Guild.Name,
+		"force":  g.DisconnectPending,
+		"reason": g.DisconnectReason,
 	}).Info("Disconnecting from voice")
+	publish(Event{Type: eventDisconnected, Guild: g.Guild.ID, Reason: g.DisconnectReason})
 	g.Disconnect()
+	saveState()
+}
+
+// Enqueues a random sound from the collection of the play if necessary when state is RNG4EVER.
+// The sound is played in the channel the play was queued for.
+func (g *Guild) continueRandom(play *Play) {
+	if g.State != RNG4EVER || g.crossfade != nil || len(g.Queued()) > 0 {
+		return
+	}
+
+	// the collection may have been emptied by deleting or moving its clips
+	coll := play.Sound.Collection
+	if coll.Empty() {
+		log.WithFields(log.Fields{
+			"guild":      g.Guild.Name,
+			"collection": coll.Prefix,
+		}).Info("Collection has no sounds left, leaving RNG4EVER mode")
+		g.SetMode(0)
+		return
+	}
+
+	if next := createPlayIn(play.User, g.Guild, play.Channel, coll, nil); next != nil {
+		queuePlay(next)
+	}
 }
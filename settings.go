@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const settingsFile = "settings.json"
+
+// GuildSettings holds the persistent per-guild options
+type GuildSettings struct {
+	// Channel policy used when playing, see follow.go
+	Follow      string `json:"follow,omitempty" yaml:"follow"`
+	HomeChannel string `json:"home_channel,omitempty" yaml:"home_channel"`
+
+	// Playback volume in percent, 100 if not set
+	Volume *int `json:"volume,omitempty" yaml:"volume"`
+
+	// Mix short clips on top of the playing clip instead of queuing them
+	Overlay bool `json:"overlay,omitempty" yaml:"overlay"`
+
+	// Length of the crossfade between consecutive clips, no crossfade if zero
+	Crossfade time.Duration `json:"crossfade,omitempty" yaml:"crossfade"`
+}
+
+// GuildOverrides holds the settings set for one guild in the configuration file or changed with commands,
+// unset fields keep the settings they are applied to
+type GuildOverrides struct {
+	Follow      *string        `json:"follow,omitempty" yaml:"follow"`
+	HomeChannel *string        `json:"home_channel,omitempty" yaml:"home_channel"`
+	Volume      *int           `json:"volume,omitempty" yaml:"volume"`
+	Overlay     *bool          `json:"overlay,omitempty" yaml:"overlay"`
+	Crossfade   *time.Duration `json:"crossfade,omitempty" yaml:"crossfade"`
+}
+
+// Apply returns the settings with the overrides set on top of them
+func (o *GuildOverrides) Apply(s GuildSettings) GuildSettings {
+	if o.Follow != nil {
+		s.Follow = *o.Follow
+	}
+	if o.HomeChannel != nil {
+		s.HomeChannel = *o.HomeChannel
+	}
+	if o.Volume != nil {
+		volume := *o.Volume
+		s.Volume = &volume
+	}
+	if o.Overlay != nil {
+		s.Overlay = *o.Overlay
+	}
+	if o.Crossfade != nil {
+		s.Crossfade = *o.Crossfade
+	}
+	return s
+}
+
+// Maps the names used in the configuration file to the stored values
+func (s *GuildSettings) normalize() {
+	if s.Follow == "off" {
+		s.Follow = followOff
+	}
+}
+
+// Maps the names used in the configuration file to the stored values
+func (o *GuildOverrides) normalize() {
+	if o.Follow != nil && *o.Follow == "off" {
+		off := followOff
+		o.Follow = &off
+	}
+}
+
+// Validate checks the settings given in the configuration file
+func (s GuildSettings) Validate() error {
+	if !scontains(s.Follow, followOff, followRequester, followBot, followHome) {
+		return fmt.Errorf("unknown follow policy \"%s\"", s.Follow)
+	}
+	if s.Follow == followHome && s.HomeChannel == "" {
+		return fmt.Errorf("home_channel is required with follow policy \"%s\"", followHome)
+	}
+	if s.Volume != nil && (*s.Volume < 0 || *s.Volume > MAX_VOLUME) {
+		return fmt.Errorf("volume must be between 0 and %d", MAX_VOLUME)
+	}
+	if s.Crossfade < 0 || s.Crossfade > MAX_CROSSFADE {
+		return fmt.Errorf("crossfade must be between 0 and %s", MAX_CROSSFADE)
+	}
+	return nil
+}
+
+var (
+	// Settings changed with commands by guild ID
+	settings      = make(map[string]*GuildOverrides)
+	settingsMutex sync.Mutex
+)
+
+// Load guild settings from disk
+func loadSettings() {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	if err := loadData(settingsFile, &settings); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to load guild settings")
+	}
+}
+
+// Save guild settings to disk, caller must hold settingsMutex
+func saveSettings() {
+	if err := saveData(settingsFile, settings); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to save guild settings")
+	}
+}
+
+// Get the overrides of the guild for changing them, caller must hold settingsMutex
+// Only the changed settings are saved, so the rest follow the configuration file when it's reloaded.
+func getSettings(guildID string) *GuildOverrides {
+	if settings[guildID] == nil {
+		settings[guildID] = &GuildOverrides{}
+	}
+	return settings[guildID]
+}
+
+// Settings returns the guild's settings, the defaults from the configuration file with the overrides applied
+func (g *Guild) Settings() GuildSettings {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	defaults := getConfig().GuildDefaults(g.Guild.ID)
+	if s := settings[g.Guild.ID]; s != nil {
+		return s.Apply(defaults)
+	}
+	return defaults
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// Set when the bot is shutting down and no longer accepts commands
+	shuttingDown int32
+
+	// Remove the discord event handlers
+	removeHandlers []func()
+)
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// Counts guilds the bot has a voice connection in
+func connectedGuilds() int {
+	count := 0
+	for _, g := range allGuilds() {
+		if g.VoiceConnection != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// Shuts the bot down within the configured deadline.
+// Events are ignored from now on, voice connections are closed after the current clips
+// (or right away), pending stats are written and finally the discord session is closed.
+func shutdown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+	for _, remove := range removeHandlers {
+		remove()
+	}
+
+	c := getConfig()
+	deadline := time.Now().Add(c.ShutdownTimeout)
+
+	log.WithFields(log.Fields{
+		"timeout":      c.ShutdownTimeout,
+		"finish_clips": c.ShutdownFinishClips,
+		"connections":  connectedGuilds(),
+	}).Info("Shutting down")
+
+	// Save playback state before it's cleared, so it can be resumed on next start
+	writeState()
+
+	// No guilds are added after the handlers are gone
+	all := allGuilds()
+	for _, g := range all {
+		if g.VoiceConnection != nil {
+			g.Stop(reasonShutdown, !c.ShutdownFinishClips)
+		}
+	}
+
+	// Players disconnect by themselves once their clips are done
+	for connectedGuilds() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for _, g := range all {
+		if g.VoiceConnection != nil {
+			log.WithFields(log.Fields{
+				"guild": g.Guild.Name,
+			}).Warning("Voice connection didn't close in time, disconnecting")
+			g.Disconnect()
+		}
+	}
+
+	// Flush stats writes still in flight
+	flushed := make(chan struct{})
+	go func() {
+		pendingStats.Wait()
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Until(deadline)):
+		log.Warning("Stats weren't written in time")
+	}
+
+	if rcli != nil {
+		rcli.Close()
+	}
+
+	if err := discord.Close(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to close discord session")
+	}
+
+	log.Info("Bye!")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"layeh.com/gopus"
+)
+
+// Silence detection modes
+const (
+	silenceOff    = "off"
+	silenceReport = "report"
+	silenceTrim   = "trim"
+)
+
+// Opus frames this small carry no audio, encoders emit them for digital silence
+const SILENT_FRAME_BYTES = 3
+
+// ModeOf returns the silence detection mode of the collection
+func (c *SilenceConfig) ModeOf(collection string) string {
+	if mode, ok := c.Collections[collection]; ok {
+		return mode
+	}
+	return c.Mode
+}
+
+// Finds which frames are silent, by decoding them if configured and by their size otherwise
+func silentFrames(frames [][]byte) []bool {
+	silent := make([]bool, len(frames))
+
+	settings := getConfig().Silence
+	var decoder *gopus.Decoder
+	if settings.Decode {
+		decoder, _ = gopus.NewDecoder(AUDIO_SAMPLE_RATE, AUDIO_CHANNELS)
+	}
+	threshold := 32767 * math.Pow(10, settings.Threshold/20)
+
+	for i, frame := range frames {
+		if decoder == nil {
+			silent[i] = len(frame) <= SILENT_FRAME_BYTES
+			continue
+		}
+
+		// Frames are decoded in order, the decoder keeps state between them
+		pcm, err := decoder.Decode(frame, AUDIO_FRAME_SIZE, false)
+		if err != nil {
+			continue
+		}
+
+		peak := 0.0
+		for _, sample := range pcm {
+			peak = math.Max(peak, math.Abs(float64(sample)))
+		}
+		silent[i] = peak < threshold
+	}
+	return silent
+}
+
+// Counts the silent frames at the start and the end of the sound
+func detectSilence(frames [][]byte) (leading int, trailing int) {
+	silent := silentFrames(frames)
+	for leading < len(silent) && silent[leading] {
+		leading++
+	}
+	for trailing < len(silent)-leading && silent[len(silent)-1-trailing] {
+		trailing++
+	}
+	return leading, trailing
+}
+
+// Sets the frames read from the sound's file. Silence at the start and the end is logged,
+// and left out of the played frames if the collection's mode is trim. The caller must hold soundsMutex.
+func (s *Sound) setFrames(frames [][]byte) {
+	s.file = frames
+	s.buffer = frames
+	s.silence = 0
+
+	mode := getConfig().Silence.ModeOf(s.Collection.Prefix)
+	if mode == silenceOff {
+		return
+	}
+
+	leading, trailing := detectSilence(frames)
+	if leading+trailing <= 0 {
+		return
+	}
+
+	if leading >= len(frames) {
+		log.WithFields(log.Fields{
+			"collection": s.Collection.Prefix,
+			"sound":      s.Name,
+		}).Warning("Sound is silent")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"collection": s.Collection.Prefix,
+		"sound":      s.Name,
+		"leading":    time.Duration(leading) * FRAME_DURATION,
+		"trailing":   time.Duration(trailing) * FRAME_DURATION,
+		"trimmed":    mode == silenceTrim,
+	}).Info("Silence detected")
+
+	if mode == silenceTrim {
+		s.buffer = frames[leading : len(frames)-trailing]
+	}
+	s.silence = leading + trailing
+}
+
+// Logs a summary of the silence found in the collection's sounds when they were loaded
+func (sc *SoundCollection) reportSilence() {
+	var (
+		count int
+		total time.Duration
+	)
+	for _, sound := range sc.Sounds {
+		if sound.silence > 0 {
+			count++
+			total += time.Duration(sound.silence) * FRAME_DURATION
+		}
+	}
+
+	if count > 0 {
+		log.WithFields(log.Fields{
+			"collection": sc.Prefix,
+			"sounds":     count,
+			"silence":    total,
+			"mode":       getConfig().Silence.ModeOf(sc.Prefix),
+		}).Info("Collection has silent parts")
+	}
+}
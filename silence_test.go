@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestDetectSilence(t *testing.T) {
+	// Without decoding, frames are silent by their size
+	c := *getConfig()
+	c.Silence.Decode = false
+	defer func(old *Config) { config = old }(config)
+	config = &c
+
+	silent, loud := []byte{0xf8, 0xff, 0xfe}, make([]byte, 100)
+
+	tests := []struct {
+		frames   [][]byte
+		leading  int
+		trailing int
+	}{
+		{[][]byte{}, 0, 0},
+		{[][]byte{loud, loud}, 0, 0},
+		{[][]byte{silent, silent, loud, silent}, 2, 1},
+		{[][]byte{loud, silent, loud}, 0, 0},
+		{[][]byte{silent, loud, silent, silent, silent}, 1, 3},
+		{[][]byte{silent, silent, silent}, 3, 0},
+	}
+
+	for i, test := range tests {
+		leading, trailing := detectSilence(test.frames)
+		if leading != test.leading || trailing != test.trailing {
+			t.Errorf("test %d: detectSilence() = %d, %d, want %d, %d", i, leading, trailing, test.leading, test.trailing)
+		}
+	}
+}
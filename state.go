@@ -0,0 +1,204 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+)
+
+const stateFile = "state.json"
+
+// PlayRef references a play by names and IDs, so it can be saved and restored
+type PlayRef struct {
+	Collection string `json:"collection"`
+	Sound      string `json:"sound"`
+	User       string `json:"user"`
+	Channel    string `json:"channel"`
+	Forced     bool   `json:"forced,omitempty"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	Effects    string `json:"effects,omitempty"`
+	Pinned     bool   `json:"pinned,omitempty"`
+
+	// Stats member of the clip, finds the clip after it has been renamed or moved
+	Clip string `json:"clip,omitempty"`
+}
+
+// GuildState is the playback state of a guild, kept over restarts
+type GuildState struct {
+	Mode  int       `json:"mode"`
+	Queue []PlayRef `json:"queue"`
+}
+
+var (
+	// Saved states of guilds which haven't been restored yet, by guild ID
+	savedStates = make(map[string]*GuildState)
+	stateMutex  sync.Mutex
+)
+
+// Create a reference to the play
+func refPlay(p *Play) PlayRef {
+	return PlayRef{
+		Collection: p.Sound.Collection.Prefix,
+		Sound:      p.Sound.Name,
+		User:       p.User.ID,
+		Channel:    p.Channel.ID,
+		Forced:     p.Forced,
+		Skipped:    p.Skipped,
+		Effects:    p.Effects.String(),
+		Pinned:     p.Pinned,
+		Clip:       clipMember(p.Sound),
+	}
+}
+
+// Finds the referenced sound, by its stats member first as the names may have changed since
+func (r PlayRef) findSound() *Sound {
+	if r.Clip != "" {
+		soundsMutex.RLock()
+		sound := findClipMember(r.Clip)
+		soundsMutex.RUnlock()
+		if sound != nil {
+			return sound
+		}
+	}
+
+	coll := findCollection(r.Collection)
+	if coll == nil {
+		return nil
+	}
+	return coll.Sound(r.Sound)
+}
+
+// Play recreates the referenced play, returns nil if the clip or the channel no longer exists
+func (r PlayRef) Play(g *Guild) *Play {
+	sound := r.findSound()
+	if sound == nil {
+		return nil
+	}
+
+	channel, _ := discord.State.Channel(r.Channel)
+	if channel == nil {
+		return nil
+	}
+
+	user := &discordgo.User{ID: r.User}
+	if member, _ := discord.State.Member(g.Guild.ID, r.User); member != nil && member.User != nil {
+		user = member.User
+	}
+
+	// Effects were formatted by the bot, so they always parse
+	effects, _, _ := parseEffects(strings.Fields(r.Effects))
+
+	return &Play{
+		Guild:   g,
+		Channel: channel,
+		User:    user,
+		Sound:   sound,
+		Forced:  r.Forced,
+		Skipped: r.Skipped,
+		Effects: effects,
+		Pinned:  r.Pinned,
+	}
+}
+
+// Snapshot captures the guild's playback state, the current play is saved as the first queued one
+func (g *Guild) Snapshot() *GuildState {
+	state := &GuildState{
+		Mode:  g.State,
+		Queue: []PlayRef{},
+	}
+
+	if playing := g.NowPlaying(); playing != nil {
+		state.Queue = append(state.Queue, refPlay(playing))
+	}
+	for _, play := range g.Queued() {
+		state.Queue = append(state.Queue, refPlay(play))
+	}
+	return state
+}
+
+// Load saved playback states from disk
+func loadState() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	if err := loadData(stateFile, &savedStates); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to load playback state")
+	}
+}
+
+// Save playback state of all guilds, unless shutting down
+// During shutdown the state is written once before the players are stopped.
+func saveState() {
+	if isShuttingDown() {
+		return
+	}
+	writeState()
+}
+
+// Write playback state of all guilds to disk
+func writeState() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	states := make(map[string]*GuildState)
+	for id, state := range savedStates {
+		states[id] = state
+	}
+	for _, g := range allGuilds() {
+		states[g.Guild.ID] = g.Snapshot()
+	}
+
+	if err := saveData(stateFile, states); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to save playback state")
+	}
+}
+
+// Restore resumes the guild's saved playback state, skipping clips that no longer exist
+func (g *Guild) Restore() {
+	stateMutex.Lock()
+	state := savedStates[g.Guild.ID]
+	delete(savedStates, g.Guild.ID)
+	stateMutex.Unlock()
+
+	if state == nil {
+		return
+	}
+
+	plays := []*Play{}
+	for _, ref := range state.Queue {
+		if play := ref.Play(g); play != nil {
+			play.Skipped = false
+			plays = append(plays, play)
+		}
+	}
+
+	if len(plays) <= 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"guild":   g.Guild.Name,
+		"mode":    state.Mode,
+		"queue":   len(plays),
+		"skipped": len(state.Queue) - len(plays),
+	}).Info("Resuming playback")
+
+	g.State = state.Mode
+
+	start := false
+	for _, play := range plays {
+		if g.Enqueue(play) {
+			start = true
+		}
+	}
+
+	if start {
+		go g.Player()
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Read the named data file into v, missing file leaves v untouched
+func loadData(name string, v interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(getConfig().DataDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Write v to the named data file
+// The file is replaced atomically, so a crash never leaves it half written.
+func saveData(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(getConfig().DataDir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(getConfig().DataDir, name)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"layeh.com/gopus"
+)
+
+// Transcoder decodes Opus frames to PCM so the audio can be changed, and encodes them again.
+// The decoder keeps state between frames, so a transcoder is used for one stream of frames.
+type Transcoder struct {
+	decoder *gopus.Decoder
+	encoder *gopus.Encoder
+
+	// What the audio is transcoded for, used as metrics label
+	reason string
+}
+
+func newTranscoder(reason string) (*Transcoder, error) {
+	decoder, err := gopus.NewDecoder(AUDIO_SAMPLE_RATE, AUDIO_CHANNELS)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder, err := gopus.NewEncoder(AUDIO_SAMPLE_RATE, AUDIO_CHANNELS, gopus.Audio)
+	if err != nil {
+		return nil, err
+	}
+	encoder.SetBitrate(getConfig().Bitrate * 1000)
+
+	return &Transcoder{
+		decoder: decoder,
+		encoder: encoder,
+		reason:  reason,
+	}, nil
+}
+
+// Decode the frame to interleaved PCM
+func (t *Transcoder) Decode(frame []byte) ([]int16, error) {
+	start := time.Now()
+	defer t.observe(start)
+
+	return t.decoder.Decode(frame, AUDIO_FRAME_SIZE, false)
+}
+
+// Encode a frame of interleaved PCM
+func (t *Transcoder) Encode(pcm []int16) ([]byte, error) {
+	start := time.Now()
+	defer t.observe(start)
+
+	return t.encoder.Encode(pcm, AUDIO_FRAME_SIZE, MAX_OPUS_FRAME_BYTES)
+}
+
+// Apply decodes the frame, changes the audio with fn and encodes it again.
+// Fails on a nil transcoder, so a failure to create one can be handled like any transcoding error.
+func (t *Transcoder) Apply(frame []byte, fn func(pcm []int16)) ([]byte, error) {
+	if t == nil {
+		return nil, errors.New("no transcoder")
+	}
+
+	pcm, err := t.Decode(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	fn(pcm)
+	t.observe(start)
+
+	metricTranscodedFrames.WithLabelValues(t.reason).Inc()
+	return t.Encode(pcm)
+}
+
+// Counts the time spent on transcoding in metrics
+func (t *Transcoder) observe(start time.Time) {
+	metricTranscodeSeconds.WithLabelValues(t.reason).Add(time.Since(start).Seconds())
+}
+
+// Clamps the sample to the range of 16-bit audio
+func clampSample(sample float64) int16 {
+	if sample > 32767 {
+		return 32767
+	}
+	if sample < -32768 {
+		return -32768
+	}
+	return int16(sample)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+	"github.com/dustin/go-humanize"
+)
+
+const uploadsFile = "uploads.json"
+
+// Directory in the data directory where uploads wait for approval
+const pendingUploadsDir = "uploads"
+
+// Client for downloading attachments, a stalled download gives up instead of hanging the upload
+var attachmentClient = &http.Client{Timeout: time.Minute}
+
+// PendingUpload is an uploaded clip waiting for approval
+type PendingUpload struct {
+	ID         string    `json:"id"`
+	Collection string    `json:"collection"`
+	Sound      string    `json:"sound"`
+	User       string    `json:"user"`
+	Username   string    `json:"username"`
+	Channel    string    `json:"channel"`
+	Time       time.Time `json:"time"`
+}
+
+var (
+	// Uploads waiting for approval by ID
+	pendingUploads = make(map[string]*PendingUpload)
+	uploadsMutex   sync.Mutex
+)
+
+// Characters not allowed in sound names, which are also file names
+var invalidSoundName = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// Load pending uploads from disk
+func loadUploads() {
+	uploadsMutex.Lock()
+	defer uploadsMutex.Unlock()
+
+	if err := loadData(uploadsFile, &pendingUploads); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to load pending uploads")
+	}
+}
+
+// Save pending uploads to disk, caller must hold uploadsMutex
+func saveUploads() {
+	if err := saveData(uploadsFile, pendingUploads); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to save pending uploads")
+	}
+}
+
+// Path of the pending upload's audio
+func (u *PendingUpload) Path() string {
+	return filepath.Join(getConfig().DataDir, pendingUploadsDir, u.ID+".dca")
+}
+
+// Turns a name into a valid sound name, returns an empty string if nothing is left
+func soundName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.Trim(invalidSoundName.ReplaceAllString(name, "_"), "_")
+}
+
+// Checks whether the user can upload clips without approval and review uploads.
+// Collections are shared by all guilds, so guild admins aren't trusted with them.
+func canUpload(userID string) bool {
+	c := getConfig()
+	return userID == c.Owner || scontains(userID, c.Upload.Users...)
+}
+
+// Writes the sound to the collection's directory and makes it playable
+func addSound(coll *SoundCollection, name string, frames [][]byte) (*Sound, error) {
+	soundsMutex.Lock()
+	defer soundsMutex.Unlock()
+
+	if coll.Taken(name) {
+		return nil, fmt.Errorf("clip `%s` already exists in `!%s`", name, coll.Prefix)
+	}
+
+	sound := createSound(name, 1, 100, coll)
+	sound.ID = newSoundID(coll, name)
+	sound.file = frames
+	if err := writeDCA(sound.Path(), frames); err != nil {
+		return nil, err
+	}
+	if err := writeSidecar(sound.sidecarPath(".id"), sound.ID); err != nil {
+		return nil, err
+	}
+
+	coll.Add(sound)
+	log.WithFields(log.Fields{
+		"collection": coll.Prefix,
+		"sound":      name,
+		"duration":   sound.Duration(),
+	}).Info("Sound added")
+	return sound, nil
+}
+
+// Downloads the attachment and converts it to Opus frames, checking the configured limits
+func convertAttachment(attachment *discordgo.MessageAttachment) ([][]byte, error) {
+	limits := getConfig().Upload
+
+	resp, err := attachmentClient.Get(attachment.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed with status %s", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(limits.MaxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limits.MaxSize {
+		return nil, fmt.Errorf("the file is larger than %s", humanize.Bytes(uint64(limits.MaxSize)))
+	}
+
+	pcm, err := decodeAudio(bytes.NewReader(data), limits.MaxDuration)
+	if err != nil {
+		return nil, err
+	}
+	if len(pcm) <= 0 {
+		return nil, fmt.Errorf("the file has no audio")
+	}
+	if len(pcm)/AUDIO_CHANNELS > samplesIn(limits.MaxDuration) {
+		return nil, fmt.Errorf("the clip is longer than %s", limits.MaxDuration)
+	}
+
+	return encodeOpus(pcm)
+}
+
+// Converts the uploaded clip, and either adds it or leaves it waiting for approval
+func processUpload(m *discordgo.MessageCreate, coll *SoundCollection, name string, approved bool) {
+	frames, err := convertAttachment(m.Attachments[0])
+	if err != nil {
+		log.WithFields(log.Fields{
+			"user":  m.Author.ID,
+			"file":  m.Attachments[0].Filename,
+			"error": err,
+		}).Warning("Failed to convert upload")
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Upload failed: %v", err))
+		return
+	}
+
+	if approved {
+		sound, err := addSound(coll, name, frames)
+		if err != nil {
+			discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Upload failed: %v", err))
+			return
+		}
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Added `!%s %s` (%s).", coll.Prefix, sound.Name, formatDuration(sound.Length())))
+		return
+	}
+
+	upload := &PendingUpload{
+		ID:         strconv.FormatInt(time.Now().UnixNano(), 36),
+		Collection: coll.Prefix,
+		Sound:      name,
+		User:       m.Author.ID,
+		Username:   m.Author.Username,
+		Channel:    m.ChannelID,
+		Time:       time.Now(),
+	}
+	if err := writeDCA(upload.Path(), frames); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to save pending upload")
+		discord.ChannelMessageSend(m.ChannelID, "Upload failed, please try again later.")
+		return
+	}
+
+	uploadsMutex.Lock()
+	pendingUploads[upload.ID] = upload
+	saveUploads()
+	uploadsMutex.Unlock()
+
+	discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Upload `%s` of `!%s %s` is waiting for approval.", upload.ID, coll.Prefix, name))
+}
+
+// Takes the pending upload out of the queue, returning nil if there's no such upload
+func takeUpload(id string) *PendingUpload {
+	uploadsMutex.Lock()
+	defer uploadsMutex.Unlock()
+
+	upload := pendingUploads[id]
+	if upload != nil {
+		delete(pendingUploads, id)
+		saveUploads()
+	}
+	return upload
+}
+
+// Approves or rejects the pending upload
+func reviewUpload(m *discordgo.MessageCreate, id string, approve bool) {
+	upload := takeUpload(id)
+	if upload == nil {
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No pending upload `%s`.", id))
+		return
+	}
+
+	if !approve {
+		os.Remove(upload.Path())
+		discord.ChannelMessageSend(upload.Channel, fmt.Sprintf("Upload `%s` of `!%s %s` was rejected.", upload.ID, upload.Collection, upload.Sound))
+		return
+	}
+
+	frames, err := readDCA(upload.Path())
+	if coll := findCollection(upload.Collection); coll == nil {
+		err = fmt.Errorf("collection `!%s` no longer exists", upload.Collection)
+	} else if err == nil {
+		_, err = addSound(coll, upload.Sound, frames)
+	}
+
+	// Failed uploads are kept, so they can be approved after the problem has been fixed
+	if err != nil {
+		uploadsMutex.Lock()
+		pendingUploads[upload.ID] = upload
+		saveUploads()
+		uploadsMutex.Unlock()
+
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Approving upload `%s` failed: %v", upload.ID, err))
+		return
+	}
+
+	os.Remove(upload.Path())
+	discord.ChannelMessageSend(upload.Channel, fmt.Sprintf("Upload `%s` was approved, `!%s %s` can now be played.", upload.ID, upload.Collection, upload.Sound))
+}
+
+// Lists the uploads waiting for approval
+func listPendingUploads(cid string) {
+	uploadsMutex.Lock()
+	lines := []string{}
+	for _, upload := range pendingUploads {
+		lines = append(lines, fmt.Sprintf("`%s` `!%s %s` by %s %s", upload.ID, upload.Collection, upload.Sound, upload.Username, humanize.Time(upload.Time)))
+	}
+	uploadsMutex.Unlock()
+
+	if len(lines) <= 0 {
+		discord.ChannelMessageSend(cid, "No uploads are waiting for approval.")
+		return
+	}
+	sendPaginated(cid, "**Pending uploads**", lines, HISTORY_PAGE_SIZE)
+}
+
+func handleUploadCommand(m *discordgo.MessageCreate, parts []string) {
+	if len(parts) < 2 {
+		discord.ChannelMessageSend(m.ChannelID, "Usage: `!upload <COLLECTION> [NAME]` with an audio file attached, `!upload pending`, `!upload approve|reject <ID>`")
+		return
+	}
+
+	// Reviewing uploads is restricted to the users who can upload
+	if scontains(parts[1], "pending", "approve", "reject") {
+		if !canUpload(m.Author.ID) {
+			discord.ChannelMessageSend(m.ChannelID, "Only the bot owner and upload users can review uploads.")
+			return
+		}
+
+		if parts[1] == "pending" {
+			listPendingUploads(m.ChannelID)
+		} else if len(parts) == 3 {
+			reviewUpload(m, parts[2], parts[1] == "approve")
+		} else {
+			discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Usage: `!upload %s <ID>`", parts[1]))
+		}
+		return
+	}
+
+	approved := canUpload(m.Author.ID)
+	if !approved && !getConfig().Upload.Approval {
+		discord.ChannelMessageSend(m.ChannelID, "You're not allowed to upload clips.")
+		return
+	}
+
+	if len(m.Attachments) != 1 {
+		discord.ChannelMessageSend(m.ChannelID, "Attach a single audio file to the message.")
+		return
+	}
+	attachment := m.Attachments[0]
+
+	if maxSize := getConfig().Upload.MaxSize; attachment.Size > maxSize {
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("The file is larger than %s.", humanize.Bytes(uint64(maxSize))))
+		return
+	}
+
+	coll := findCollection(parts[1])
+	if coll == nil {
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Unknown collection `%s`, see `!list`.", parts[1]))
+		return
+	}
+
+	name := soundName(strings.TrimSuffix(attachment.Filename, filepath.Ext(attachment.Filename)))
+	if len(parts) > 2 {
+		name = soundName(strings.Join(parts[2:], "_"))
+	}
+	if name == "" {
+		discord.ChannelMessageSend(m.ChannelID, "Give the clip a name with letters or numbers.")
+		return
+	}
+	soundsMutex.RLock()
+	taken := coll.Taken(name)
+	soundsMutex.RUnlock()
+	if taken {
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Clip `%s` already exists in `!%s`.", name, coll.Prefix))
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"user":       m.Author.ID,
+		"collection": coll.Prefix,
+		"sound":      name,
+		"file":       attachment.Filename,
+		"approved":   approved,
+	}).Info("Converting upload")
+
+	go processUpload(m, coll, name, approved)
+}
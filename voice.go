@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// Follows users joining and leaving voice channels
+func onVoiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	guild, _ := s.State.Guild(v.GuildID)
+	if guild == nil {
+		return
+	}
+
+	guildData := getGuild(guild)
+	guildData.CheckAlone()
+
+	if v.UserID == s.State.Ready.User.ID {
+		return
+	}
+
+	// Only joins are interesting, not moves, mutes or leaves
+	if !guildData.userMoved(v.UserID, v.ChannelID) {
+		return
+	}
+
+	playIntro(guildData, v.UserID, v.ChannelID)
+}
+
+// Records the user's voice channel, empty if they left voice. Returns true if the user joined voice.
+func (g *Guild) userMoved(userID string, channelID string) bool {
+	g.userMutex.Lock()
+	defer g.userMutex.Unlock()
+
+	previous := g.UserChannels[userID]
+	if channelID == "" {
+		delete(g.UserChannels, userID)
+		return false
+	}
+	g.UserChannels[userID] = channelID
+	return previous == ""
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Volume limits in percent, clips are played as they are at the default volume
+const (
+	DEFAULT_VOLUME = 100
+	MAX_VOLUME     = 200
+)
+
+// Volume returns the guild's playback volume in percent
+func (g *Guild) Volume() int {
+	if g == nil {
+		return DEFAULT_VOLUME
+	}
+	if volume := g.Settings().Volume; volume != nil {
+		return *volume
+	}
+	return DEFAULT_VOLUME
+}
+
+// Multiplies the PCM by the volume, clipping samples that get too loud
+func applyVolume(pcm []int16, volume int) {
+	gain := float64(volume) / 100
+	for i, sample := range pcm {
+		pcm[i] = clampSample(float64(sample) * gain)
+	}
+}
+
+// Handles the !volume [0-200] command
+func handleVolumeCommand(m *discordgo.MessageCreate, parts []string, guild *discordgo.Guild) {
+	usage := fmt.Sprintf("Usage: `!volume 0-%d`", MAX_VOLUME)
+	if len(parts) < 2 {
+		discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Volume is %d%%. %s", getGuild(guild).Volume(), usage))
+		return
+	}
+
+	volume, err := strconv.Atoi(strings.TrimSuffix(parts[1], "%"))
+	if err != nil || volume < 0 || volume > MAX_VOLUME {
+		discord.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+
+	settingsMutex.Lock()
+	s := getSettings(guild.ID)
+	s.Volume = &volume
+	saveSettings()
+	settingsMutex.Unlock()
+
+	discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Volume is now %d%%.", volume))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyVolume(t *testing.T) {
+	tests := []struct {
+		pcm    []int16
+		volume int
+		want   []int16
+	}{
+		{[]int16{100, -100, 32767}, 100, []int16{100, -100, 32767}},
+		{[]int16{100, -100, 0}, 50, []int16{50, -50, 0}},
+		{[]int16{100, -100}, 0, []int16{0, 0}},
+		{[]int16{100, -100, 20000, -20000}, 200, []int16{200, -200, 32767, -32768}},
+	}
+
+	for _, test := range tests {
+		pcm := append([]int16{}, test.pcm...)
+		applyVolume(pcm, test.volume)
+		if !reflect.DeepEqual(pcm, test.want) {
+			t.Errorf("applyVolume(%v, %d) = %v, want %v", test.pcm, test.volume, pcm, test.want)
+		}
+	}
+}
+
+func TestClampSample(t *testing.T) {
+	tests := []struct {
+		sample float64
+		want   int16
+	}{
+		{0, 0},
+		{1234.7, 1234},
+		{-1234.7, -1234},
+		{32767, 32767},
+		{40000, 32767},
+		{-32768, -32768},
+		{-40000, -32768},
+	}
+
+	for _, test := range tests {
+		if got := clampSample(test.sample); got != test.want {
+			t.Errorf("clampSample(%v) = %d, want %d", test.sample, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Serves the soundboard page, which uses the HTTP API with the token the user logs in with
+func handleSoundboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(soundboardPage))
+}
+
+const soundboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>NiksiBot</title>
+<style>
+body { margin: 0; font-family: sans-serif; background: #2f3136; color: #dcddde; }
+header { display: flex; gap: 1em; align-items: center; padding: 0.5em 1em; background: #202225; }
+header h1 { font-size: 1.2em; margin: 0; flex: 1; }
+main { display: flex; gap: 1em; padding: 1em; }
+#collections { flex: 3; }
+#status { flex: 1; min-width: 16em; }
+h2 { font-size: 1em; display: flex; gap: 0.5em; align-items: center; }
+.clips { display: flex; flex-wrap: wrap; gap: 0.3em; }
+button { background: #4f545c; color: #fff; border: 0; border-radius: 3px; padding: 0.4em 0.7em; cursor: pointer; }
+button:hover { background: #5865f2; }
+button.small { font-size: 0.8em; padding: 0.2em 0.5em; }
+ol, ul { padding-left: 1.5em; }
+.skipped { text-decoration: line-through; }
+.muted { color: #8e9297; }
+#error { color: #ed4245; }
+#login { max-width: 24em; margin: 4em auto; display: flex; flex-direction: column; gap: 0.5em; }
+input, select { padding: 0.4em; }
+</style>
+</head>
+<body>
+<form id="login" hidden>
+  <h1>NiksiBot</h1>
+  <label>API token <input id="token" type="password" autocomplete="current-password"></label>
+  <button>Log in</button>
+  <span id="login-error" class="muted"></span>
+</form>
+<div id="app" hidden>
+  <header>
+    <h1>NiksiBot</h1>
+    <span id="me" class="muted"></span>
+    <select id="guild"></select>
+    <button id="logout">Log out</button>
+  </header>
+  <main>
+    <section id="collections"></section>
+    <section id="status">
+      <div id="error"></div>
+      <p>
+        <button id="skip">Skip</button>
+        <button id="stop">Stop</button>
+        <button id="normal">Normal mode</button>
+      </p>
+      <p id="mode" class="muted"></p>
+      <h2>Now playing</h2>
+      <p id="playing" class="muted">Nothing</p>
+      <h2>Queue</h2>
+      <ol id="queue"></ol>
+      <h2>History</h2>
+      <ul id="history"></ul>
+    </section>
+  </main>
+</div>
+<script>
+var token = localStorage.getItem("niksibot-token");
+var guild = localStorage.getItem("niksibot-guild");
+var events = null;
+
+function $(id) { return document.getElementById(id); }
+
+function escape(text) {
+  var div = document.createElement("div");
+  div.textContent = text;
+  return div.innerHTML;
+}
+
+function api(method, path, body) {
+  return fetch("/api/" + path, {
+    method: method,
+    headers: {"Authorization": "Bearer " + token, "Content-Type": "application/json"},
+    body: body ? JSON.stringify(body) : undefined
+  }).then(function (res) {
+    return res.json().then(function (data) {
+      if (!res.ok) {
+        var message = data.error;
+        if (data.suggestions && data.suggestions.length) {
+          message += ", did you mean " + data.suggestions.join(", ");
+        }
+        throw new Error(message);
+      }
+      return data;
+    });
+  });
+}
+
+function showError(err) {
+  $("error").textContent = err ? err.message : "";
+}
+
+function describe(ref) {
+  return escape(ref.collection + " " + ref.sound);
+}
+
+function play(collection, sound) {
+  return api("POST", "guilds/" + guild + "/play", {collection: collection, sound: sound})
+    .then(function () { showError(); refresh(); }, showError);
+}
+
+function control(action, body) {
+  return api("POST", "guilds/" + guild + "/" + action, body)
+    .then(function () { showError(); refresh(); }, showError);
+}
+
+function renderCollections(collections) {
+  var root = $("collections");
+  root.innerHTML = "";
+  collections.forEach(function (coll) {
+    var title = document.createElement("h2");
+    title.textContent = "!" + coll.name;
+
+    var random = document.createElement("button");
+    random.className = "small";
+    random.textContent = "random";
+    random.onclick = function () { play(coll.name); };
+
+    var rng = document.createElement("button");
+    rng.className = "small";
+    rng.textContent = "rng4ever";
+    rng.onclick = function () {
+      control("mode", {mode: "rng4ever"}).then(function () { play(coll.name); });
+    };
+
+    title.appendChild(random);
+    title.appendChild(rng);
+    root.appendChild(title);
+
+    var clips = document.createElement("div");
+    clips.className = "clips";
+    coll.sounds.forEach(function (sound) {
+      var button = document.createElement("button");
+      button.textContent = sound.name;
+      button.title = sound.duration.toFixed(1) + "s" +
+        (sound.aliases ? ", also " + sound.aliases.join(", ") : "");
+      button.onclick = function () { play(coll.name, sound.name); };
+      clips.appendChild(button);
+    });
+    root.appendChild(clips);
+  });
+}
+
+function renderState(state) {
+  $("mode").textContent = state.mode + (state.connected ? ", connected" : ", not connected");
+  $("playing").innerHTML = state.playing ? describe(state.playing) : "Nothing";
+  $("queue").innerHTML = state.queue.map(function (ref) {
+    return "<li>" + describe(ref) + "</li>";
+  }).join("");
+}
+
+function renderHistory(history) {
+  $("history").innerHTML = (history || []).map(function (entry) {
+    return "<li" + (entry.skipped ? " class=\"skipped\"" : "") + ">" + describe(entry) +
+      " <span class=\"muted\">" + escape(entry.username) + " " +
+      new Date(entry.time).toLocaleTimeString() + "</span></li>";
+  }).join("");
+}
+
+function refresh() {
+  if (!guild || $("app").hidden) {
+    return;
+  }
+  api("GET", "guilds/" + guild).then(renderState, showError);
+  api("GET", "guilds/" + guild + "/history?n=20").then(renderHistory, showError);
+}
+
+// Refresh whenever something happens in the guild, EventSource reconnects by itself
+function listen() {
+  if (events) {
+    events.close();
+    events = null;
+  }
+  if (!guild || !token) {
+    return;
+  }
+  events = new EventSource("/api/events?guild=" + encodeURIComponent(guild) +
+    "&token=" + encodeURIComponent(token));
+  ["enqueued", "started", "skipped", "finished", "disconnected", "mode_changed"].forEach(function (type) {
+    events.addEventListener(type, refresh);
+  });
+}
+
+function start() {
+  api("GET", "me").then(function (me) {
+    $("login").hidden = true;
+    $("app").hidden = false;
+    $("me").textContent = me.name + (me.user ? "" : " (token has no user, plays will fail)");
+
+    api("GET", "collections").then(renderCollections, showError);
+    api("GET", "guilds").then(function (guilds) {
+      var select = $("guild");
+      select.innerHTML = guilds.map(function (g) {
+        return "<option value=\"" + escape(g.id) + "\">" + escape(g.name) + "</option>";
+      }).join("");
+      if (!guilds.some(function (g) { return g.id === guild; })) {
+        guild = guilds.length ? guilds[0].id : null;
+      }
+      select.value = guild;
+      refresh();
+      listen();
+    }, showError);
+  }, function (err) {
+    $("login").hidden = false;
+    $("app").hidden = true;
+    $("login-error").textContent = token ? err.message : "";
+  });
+}
+
+$("login").onsubmit = function (e) {
+  e.preventDefault();
+  token = $("token").value;
+  localStorage.setItem("niksibot-token", token);
+  start();
+};
+
+$("logout").onclick = function () {
+  localStorage.removeItem("niksibot-token");
+  token = null;
+  listen();
+  start();
+};
+
+$("guild").onchange = function () {
+  guild = this.value;
+  localStorage.setItem("niksibot-guild", guild);
+  refresh();
+  listen();
+};
+
+$("skip").onclick = function () { control("skip"); };
+$("stop").onclick = function () { control("stop"); };
+$("normal").onclick = function () { control("mode", {mode: "normal"}); };
+
+start();
+</script>
+</body>
+</html>
+`